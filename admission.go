@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/admission/plugin/webhook/generic"
+	"k8s.io/apiserver/pkg/admission/plugin/webhook/mutating"
+	"k8s.io/apiserver/pkg/admission/plugin/webhook/validating"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/util/dryrun"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+)
+
+// newWebhookAdmission returns the admission chain that calls the mutating,
+// then the validating, admission webhooks of the cluster, like the
+// kube-apiserver does. The webhook configurations and the namespaces their
+// namespaceSelector is matched against are watched with the informers of the
+// factory, which must be started afterwards. Requests are Forbidden until the
+// informers have synced.
+func newWebhookAdmission(client kubernetes.Interface, informerFactory informers.SharedInformerFactory, auth authorizer.Authorizer) (admission.Interface, error) {
+	mutatingWebhook, err := mutating.NewMutatingWebhook(nil)
+	if err != nil {
+		return nil, err
+	}
+	validatingWebhook, err := validating.NewValidatingAdmissionWebhook(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, webhook := range []*generic.Webhook{mutatingWebhook.Webhook, validatingWebhook.Webhook} {
+		webhook.SetExternalKubeClientSet(client)
+		webhook.SetExternalKubeInformerFactory(informerFactory)
+		// The authorizer of the CEL matchConditions
+		webhook.SetAuthorizer(auth)
+		if err := webhook.ValidateInitialization(); err != nil {
+			return nil, errors.Wrap(err, "could not initialize admission webhooks")
+		}
+	}
+	return admission.NewChainHandler(mutatingWebhook, validatingWebhook), nil
+}
+
+// SetAdmission sets the admission chain that objects are admitted by before
+// they're written. Nothing is admitted if it's nil.
+func (as *APIServer) SetAdmission(admit admission.Interface) {
+	as.admission = admit
+}
+
+// admit runs the mutating, then the validating, admission of the operation.
+// Mutations are made to obj in place. obj is nil for deletes, and oldObj for
+// creates.
+func (as *APIServer) admit(ctx context.Context, scope *requestScope, obj, oldObj k8sruntime.Object, operation admission.Operation, options k8sruntime.Object, dryRun []string) error {
+	if err := as.mutate(ctx, scope, obj, oldObj, operation, options, dryRun); err != nil {
+		return err
+	}
+	return as.validate(ctx, scope, obj, oldObj, operation, options, dryRun)
+}
+
+// mutate runs the mutating admission of the operation. Mutations are made to
+// obj in place.
+func (as *APIServer) mutate(ctx context.Context, scope *requestScope, obj, oldObj k8sruntime.Object, operation admission.Operation, options k8sruntime.Object, dryRun []string) error {
+	mutator, ok := as.admission.(admission.MutationInterface)
+	if !ok || !mutator.Handles(operation) {
+		return nil
+	}
+	attrs, err := admissionAttributes(ctx, scope, obj, oldObj, operation, options, dryRun)
+	if err != nil {
+		return err
+	}
+
+	if err := mutator.Admit(ctx, attrs, as.admissionObjectInterfaces()); err != nil {
+		return err
+	}
+	// Webhooks with the IfNeeded reinvocationPolicy are called again when
+	// later webhooks changed the object
+	if reinvocation := attrs.GetReinvocationContext(); reinvocation.ShouldReinvoke() {
+		reinvocation.SetIsReinvoke()
+		return mutator.Admit(ctx, attrs, as.admissionObjectInterfaces())
+	}
+	return nil
+}
+
+// validate runs the validating admission of the operation. Like the
+// kube-apiserver, it's run by the storages on the object they're about to
+// write, once they've set the fields set by the server and validated it.
+func (as *APIServer) validate(ctx context.Context, scope *requestScope, obj, oldObj k8sruntime.Object, operation admission.Operation, options k8sruntime.Object, dryRun []string) error {
+	validator, ok := as.admission.(admission.ValidationInterface)
+	if !ok || !validator.Handles(operation) {
+		return nil
+	}
+	attrs, err := admissionAttributes(ctx, scope, obj, oldObj, operation, options, dryRun)
+	if err != nil {
+		return err
+	}
+	return validator.Validate(ctx, attrs, as.admissionObjectInterfaces())
+}
+
+func admissionAttributes(ctx context.Context, scope *requestScope, obj, oldObj k8sruntime.Object, operation admission.Operation, options k8sruntime.Object, dryRun []string) (admission.Attributes, error) {
+	u, ok := genericapirequest.UserFrom(ctx)
+	if !ok {
+		return nil, apierrors.NewInternalError(errors.New("no user in the context of the request"))
+	}
+	named := obj
+	if named == nil {
+		named = oldObj
+	}
+	accessor, err := meta.Accessor(named)
+	if err != nil {
+		return nil, err
+	}
+
+	return admission.NewAttributesRecord(
+		obj,
+		oldObj,
+		scope.GroupVersion.WithKind(scope.Resource.Kind),
+		scope.Namespace,
+		accessor.GetName(),
+		scope.GroupVersion.WithResource(scope.Resource.Name),
+		"",
+		operation,
+		options,
+		dryrun.IsDryRun(dryRun),
+		u,
+	), nil
+}
+
+func (as *APIServer) admissionObjectInterfaces() admission.ObjectInterfaces {
+	return &admission.RuntimeObjectInterfaces{
+		ObjectCreater:            Scheme,
+		ObjectTyper:              Scheme,
+		ObjectDefaulter:          Scheme,
+		ObjectConvertor:          Scheme,
+		EquivalentResourceMapper: as.equivalentResources,
+	}
+}
+
+// mutateUpdate returns a TransformFunc that runs the mutating admission of
+// the updated object. Updates that create the object are admitted as
+// creates.
+func (as *APIServer) mutateUpdate(scope *requestScope, options *metav1.UpdateOptions) TransformFunc {
+	return func(ctx context.Context, newObj k8sruntime.Object, oldObj k8sruntime.Object) (k8sruntime.Object, error) {
+		accessor, err := meta.Accessor(oldObj)
+		if err != nil {
+			return nil, err
+		}
+		if accessor.GetUID() == "" {
+			err = as.mutate(ctx, scope, newObj, nil, admission.Create, createOptions(options), options.DryRun)
+		} else {
+			err = as.mutate(ctx, scope, newObj, oldObj, admission.Update, options, options.DryRun)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return newObj, nil
+	}
+}
+
+// validateCreate returns the ValidateObjectFunc that runs the validating
+// admission of the created object.
+func (as *APIServer) validateCreate(scope *requestScope, options *metav1.CreateOptions) ValidateObjectFunc {
+	return func(ctx context.Context, obj k8sruntime.Object) error {
+		return as.validate(ctx, scope, obj, nil, admission.Create, options, options.DryRun)
+	}
+}
+
+// validateUpdate returns the ValidateObjectUpdateFunc that runs the
+// validating admission of the updated object.
+func (as *APIServer) validateUpdate(scope *requestScope, options *metav1.UpdateOptions) ValidateObjectUpdateFunc {
+	return func(ctx context.Context, obj, oldObj k8sruntime.Object) error {
+		return as.validate(ctx, scope, obj, oldObj, admission.Update, options, options.DryRun)
+	}
+}
+
+// admitDelete returns the ValidateObjectFunc that admits the deletion of the
+// object.
+func (as *APIServer) admitDelete(scope *requestScope, options *metav1.DeleteOptions) ValidateObjectFunc {
+	return func(ctx context.Context, obj k8sruntime.Object) error {
+		return as.admit(ctx, scope, nil, obj, admission.Delete, options, options.DryRun)
+	}
+}
+
+// createOptions returns the options of the create made by an update of an
+// object that doesn't exist.
+func createOptions(options *metav1.UpdateOptions) *metav1.CreateOptions {
+	return &metav1.CreateOptions{
+		DryRun:          options.DryRun,
+		FieldManager:    options.FieldManager,
+		FieldValidation: options.FieldValidation,
+	}
+}
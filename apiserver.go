@@ -17,24 +17,36 @@ package main
 
 import (
 	"fmt"
+	"mime"
 	"net/http"
 	"reflect"
 	"strings"
 
 	"agones.dev/agones/pkg/util/https"
 	"agones.dev/agones/pkg/util/runtime"
-	restful "github.com/emicklei/go-restful/v3"
 	"github.com/munnerz/goautoneg"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1beta1 "k8s.io/apimachinery/pkg/apis/meta/v1beta1"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
-	"k8s.io/apiserver/pkg/endpoints/openapi"
-	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/endpoints/discovery/aggregated"
+	"k8s.io/apiserver/pkg/endpoints/filters"
+	"k8s.io/apiserver/pkg/endpoints/handlers/negotiation"
+	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
 	kmux "k8s.io/apiserver/pkg/server/mux"
-	"k8s.io/apiserver/pkg/server/routes"
+	"k8s.io/kube-openapi/pkg/builder"
+	"k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/handler"
+	"k8s.io/kube-openapi/pkg/handler3"
 )
 
 var (
@@ -43,12 +55,21 @@ var (
 	Scheme = k8sruntime.NewScheme()
 	// Codecs for unversioned types - such as APIResourceList, and Status
 	Codecs = serializer.NewCodecFactory(Scheme)
+	// ResourceCodecs are the Codecs the objects of resources are negotiated
+	// with. Like for CRDs, they aren't served as protobuf since their types
+	// don't implement protobuf marshalling.
+	ResourceCodecs k8sruntime.NegotiatedSerializer = withoutProtobuf{Codecs}
 
 	unversionedVersion = schema.GroupVersion{Version: "v1"}
 	unversionedTypes   = []k8sruntime.Object{
 		&metav1.Status{},
 		&metav1.APIResourceList{},
+		&metav1.APIGroup{},
+		&metav1.APIGroupList{},
 	}
+
+	// errNotFound is returned for paths that don't match any resource
+	errNotFound = apierrors.NewGenericServerResponse(http.StatusNotFound, "", schema.GroupResource{}, "", "", 0, false)
 )
 
 const (
@@ -60,90 +81,78 @@ const (
 
 func init() {
 	Scheme.AddUnversionedTypes(unversionedVersion, unversionedTypes...)
+	// Tables for kubectl get
+	utilruntime.Must(metav1.AddMetaToScheme(Scheme))
+	utilruntime.Must(metav1beta1.AddMetaToScheme(Scheme))
 }
 
-// CRDHandler is a http handler, that gets passed the Namespace it's working
-// on, and returns an error if a server error occurs
-type CRDHandler func(http.ResponseWriter, *http.Request, string) error
-
 // APIServer is a lightweight library for registering, and providing handlers
 // for Kubernetes APIServer extensions.
 type APIServer struct {
 	logger              *logrus.Entry
 	mux                 *http.ServeMux
 	resourceList        map[schema.GroupVersion]*metav1.APIResourceList
-	delegates           map[schema.GroupVersionResource]CRDHandler
-	namespacedDelegates map[schema.GroupVersionResource]CRDHandler
+	groups              map[string]*metav1.APIGroup
+	groupList           *metav1.APIGroupList
+	discovery           aggregated.ResourceManager
+	priorities          map[schema.GroupVersion]groupVersionPriority
+	openAPIConfig       *common.Config
+	openAPIV3Config     *common.OpenAPIV3Config
+	openAPIV2           *handler.OpenAPIService
+	openAPIV3           *handler3.OpenAPIService
+	delegates           map[schema.GroupVersionResource]*apiResource
+	namespacedDelegates map[schema.GroupVersionResource]*apiResource
+	// authenticator authenticates the requests made against resources.
+	// Requests are Unauthorized if it's nil.
+	authenticator authenticator.Request
+	// authorizer authorizes the requests made against resources. Requests
+	// are only authorized by the aggregator if it's nil.
+	authorizer authorizer.Authorizer
+	// admission admits the objects written to resources, eg: by calling
+	// admission webhooks. Nothing is admitted if it's nil.
+	admission admission.Interface
+	// equivalentResources are the versions each resource is served in, which
+	// admission webhooks with the Equivalent matchPolicy match.
+	equivalentResources k8sruntime.EquivalentResourceRegistry
 }
 
-func noop(*restful.Request, *restful.Response) {
+// apiResource is a resource registered with AddAPIResource along with the
+// storage that serves it.
+type apiResource struct {
+	metav1.APIResource
+	storage      Storage
+	fieldManager *managedfields.FieldManager
 }
 
 // NewAPIServer returns a new API Server from the given Mux.
 // creates a empty Swagger definition and sets up the endpoint.
 func NewAPIServer(mux *http.ServeMux) *APIServer {
-	oapiConfigV2 := genericapiserver.DefaultOpenAPIConfig(
-		getDefinitions,
-		openapi.NewDefinitionNamer(Scheme),
-	)
-
-	oapiConfigV3 := genericapiserver.DefaultOpenAPIV3Config(
-		getDefinitions,
-		openapi.NewDefinitionNamer(Scheme),
-	)
-
-	// TODO: Get rid of NewDefinitionNamer because it keeps the whole
-	// type's PkgPath which isn't what we want.
-	// eg: openapi-mux.apis.tomlebreux.com.v1alpha1.RancherToken
-	//      instead of
-	//     com.tomlebreux.v1alpha1.RancherToken
-
-	oapiRoutes := routes.OpenAPI{
-		Config:   oapiConfigV2,
-		V3Config: oapiConfigV3,
-	}
+	openAPIConfig, openAPIV3Config := newOpenAPIConfigs(Scheme)
 
-	theMux := kmux.NewPathRecorderMux("hi")
+	// The specs are updated by AddAPIResource
+	swagger, err := builder.BuildOpenAPISpecFromRoutes(nil, openAPIConfig)
+	must(err)
+	openAPIV2 := handler.NewOpenAPIService(swagger)
+	openAPIV3 := handler3.NewOpenAPIService()
 
-	container := restful.NewContainer()
-	ws := &restful.WebService{}
-	ws.Path("/apis/tomlebreux.com/v1alpha1")
-	ws.Route(
-		ws.GET("/namespaces/{namespace}/ranchertokens").
-			To(noop).
-			// Ends up being changed to listTomlebreuxComV1alpha1RancherTokens ..
-			Operation("listRancherTokens").
-			Doc("list all your tokens").
-			Consumes(restful.MIME_JSON).
-			Produces(restful.MIME_JSON).
-			Reads(&RancherToken{}).
-			Returns(200, "OK", &RancherToken{}).
-			// Whatever, just testing things
-			ReturnsError(404, "not found", &RancherToken{}),
-	)
-	ws.Route(
-		ws.DELETE("/namespaces/{namespace}/ranchertokens/{name}").
-			To(noop).
-			// Ends up being changed to listTomlebreuxComV1alpha1RancherTokens ..
-			Operation("deleteRancherTokens").
-			Doc("delete the token").
-			Consumes(restful.MIME_JSON).
-			Produces(restful.MIME_JSON).
-			Reads(&RancherToken{}).
-			Returns(200, "OK", &RancherToken{}).
-			// Whatever, just testing things
-			ReturnsError(404, "not found", &RancherToken{}),
-	)
-	container.Add(ws)
-
-	oapiRoutes.InstallV2(container, theMux)
-	oapiRoutes.InstallV3(container, theMux)
+	theMux := kmux.NewPathRecorderMux("hi")
+	openAPIV2.RegisterOpenAPIVersionedService("/openapi/v2", theMux)
+	must(openAPIV3.RegisterOpenAPIV3VersionedService("/openapi/v3", theMux))
 
 	s := &APIServer{
 		mux:                 mux,
 		resourceList:        map[schema.GroupVersion]*metav1.APIResourceList{},
-		delegates:           map[schema.GroupVersionResource]CRDHandler{},
-		namespacedDelegates: map[schema.GroupVersionResource]CRDHandler{},
+		groups:              map[string]*metav1.APIGroup{},
+		groupList:           &metav1.APIGroupList{Groups: []metav1.APIGroup{}},
+		discovery:           aggregated.NewResourceManager("apis"),
+		priorities:          map[schema.GroupVersion]groupVersionPriority{},
+		openAPIConfig:       openAPIConfig,
+		openAPIV3Config:     openAPIV3Config,
+		openAPIV2:           openAPIV2,
+		openAPIV3:           openAPIV3,
+		delegates:           map[schema.GroupVersionResource]*apiResource{},
+		namespacedDelegates: map[schema.GroupVersionResource]*apiResource{},
+		equivalentResources: k8sruntime.NewEquivalentResourceRegistry(),
 	}
 	s.logger = runtime.NewLoggerWithType(s)
 	s.logger.Debug("API Server Started")
@@ -155,15 +164,9 @@ func NewAPIServer(mux *http.ServeMux) *APIServer {
 
 	// This endpoint serves APIGroupDiscoveryList objects which is defined in this
 	// KEP: https://github.com/kubernetes/enhancements/blob/master/keps/sig-api-machinery/3352-aggregated-discovery/
-	// Since it's only GA in v1.30, we don't need to support it yet.
-	//
-	// Apparently Aggregate Discovery expects a 406, otherwise namespaces don't successfully terminate on <= 1.27.2
-	// (according to the code I forked)
-	mux.HandleFunc("/apis", func(w http.ResponseWriter, r *http.Request) {
-		https.LogRequest(s.logger, r).Info("TopLevel /apis")
-		w.WriteHeader(http.StatusNotAcceptable)
-		w.Header().Set(ContentTypeHeader, k8sruntime.ContentTypeJSON)
-	})
+	// when the client asks for it (apidiscovery.k8s.io v2, or v2beta1 for
+	// older clients), and the legacy APIGroupList otherwise.
+	mux.Handle("/apis", aggregated.WrapAggregatedDiscoveryToHandler(s.serializedHandler(s.groupList), s.discovery))
 
 	return s
 }
@@ -171,8 +174,11 @@ func NewAPIServer(mux *http.ServeMux) *APIServer {
 // AddAPIResource stores the APIResource under the given groupVersion string, and returns it
 // in the appropriate place for the K8s discovery service
 // e.g. http://localhost:8001/apis/scheduling.k8s.io/v1
-// as well as registering a CRDHandler that all http requests for the given APIResource are routed to
-func (as *APIServer) AddAPIResource(groupVersion schema.GroupVersion, resource metav1.APIResource, handler CRDHandler) {
+// as well as registering the Storage that all http requests for the given APIResource are routed to.
+// The verbs of the APIResource are derived from the interfaces the Storage implements.
+func (as *APIServer) AddAPIResource(groupVersion schema.GroupVersion, resource metav1.APIResource, storage Storage) {
+	resource.Verbs = storageVerbs(storage)
+
 	_, ok := as.resourceList[groupVersion]
 	if !ok {
 		// discovery handler
@@ -182,17 +188,27 @@ func (as *APIServer) AddAPIResource(groupVersion schema.GroupVersion, resource m
 		as.addSerializedHandler(pattern, list)
 		as.logger.WithField("groupversion", groupVersion).WithField("pattern", pattern).Info("Adding Discovery Handler")
 
+		if _, ok := as.groups[groupVersion.Group]; !ok {
+			group := &metav1.APIGroup{Name: groupVersion.Group}
+			as.groups[groupVersion.Group] = group
+			pattern = fmt.Sprintf("/apis/%s", groupVersion.Group)
+			as.addSerializedHandler(pattern, group)
+			as.logger.WithField("group", groupVersion.Group).WithField("pattern", pattern).Info("Adding Group Discovery Handler")
+		}
+		as.updateGroups()
+
 		pattern = fmt.Sprintf("/apis/%s/namespaces/", groupVersion)
-		as.mux.HandleFunc(pattern, https.ErrorHTTPHandler(as.logger, as.namespacedResourceHandler(groupVersion)))
+		as.mux.Handle(pattern, filters.WithWarningRecorder(as.errorHTTPHandler(groupVersion, as.namespacedResourceHandler(groupVersion))))
 		as.logger.WithField("groupversion", groupVersion.String()).WithField("pattern", pattern).WithField("namespaced", "true").Info("Adding Resource Handler")
 
 		pattern = fmt.Sprintf("/apis/%s/", groupVersion)
-		as.mux.HandleFunc(pattern, https.ErrorHTTPHandler(as.logger, as.resourceHandler(groupVersion)))
+		as.mux.Handle(pattern, filters.WithWarningRecorder(as.errorHTTPHandler(groupVersion, as.resourceHandler(groupVersion))))
 		as.logger.WithField("groupversion", groupVersion.String()).WithField("pattern", pattern).WithField("namespaced", "false").Info("Adding Resource Handler")
 	}
 
 	// discovery resource
 	as.resourceList[groupVersion].APIResources = append(as.resourceList[groupVersion].APIResources, resource)
+	as.updateAggregatedDiscovery(groupVersion)
 
 	// add specific crd resource handler
 	gvr := schema.GroupVersionResource{
@@ -200,23 +216,44 @@ func (as *APIServer) AddAPIResource(groupVersion schema.GroupVersion, resource m
 		Version:  groupVersion.Version,
 		Resource: resource.Name,
 	}
+	delegate := &apiResource{APIResource: resource, storage: storage}
+	fieldManager, err := newFieldManager(as.openAPIV3Config, groupVersion.WithKind(resource.Kind))
+	if err != nil {
+		as.logger.WithError(err).WithField("groupversion", groupVersion).WithField("resource", resource.Name).Error("Could not create field manager, managedFields won't be tracked")
+	} else {
+		delegate.fieldManager = fieldManager
+	}
+	as.equivalentResources.RegisterKindFor(gvr, "", groupVersion.WithKind(resource.Kind))
 	if resource.Namespaced {
-		as.namespacedDelegates[gvr] = handler
+		as.namespacedDelegates[gvr] = delegate
 	} else {
-		as.delegates[gvr] = handler
+		as.delegates[gvr] = delegate
 	}
+	as.updateOpenAPI(groupVersion)
 
 	as.logger.WithField("groupversion", groupVersion).WithField("apiresource", resource).Info("Adding APIResource")
 }
 
+// SetAuthenticator sets the authenticator of the requests made against
+// resources. The user it returns is set in the context of the request.
+func (as *APIServer) SetAuthenticator(auth authenticator.Request) {
+	as.authenticator = auth
+}
+
+// SetAuthorizer sets the authorizer of the requests made against resources,
+// once they're authenticated.
+func (as *APIServer) SetAuthorizer(auth authorizer.Authorizer) {
+	as.authorizer = auth
+}
+
 // Namespaced: /apis/tomlebreux.com/v1/namespaces/<namespace>/<resource>
-// namespacedResourceHandler handles namespaced resource calls, and sends them to the appropriate CRDHandler delegate
+// namespacedResourceHandler handles namespaced resource calls, and sends them to the appropriate Storage delegate
 func (as *APIServer) namespacedResourceHandler(groupVersion schema.GroupVersion) https.ErrorHandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		namespace, resource, err := splitNamespaceResource(r.URL.Path)
+		namespace, resource, name, err := splitNamespaceResource(r.URL.Path)
 		if err != nil {
-			https.FourZeroFour(as.logger.WithError(err), w, r)
-			return nil
+			as.logger.WithError(err).Debug("Could not split path")
+			return errNotFound
 		}
 
 		gvr := schema.GroupVersionResource{
@@ -226,25 +263,27 @@ func (as *APIServer) namespacedResourceHandler(groupVersion schema.GroupVersion)
 		}
 		delegate, ok := as.namespacedDelegates[gvr]
 		if !ok {
-			https.FourZeroFour(as.logger, w, r)
-			return nil
-		}
-
-		if err := delegate(w, r, namespace); err != nil {
-			return err
+			return errNotFound
 		}
 
-		return nil
+		return as.serveResource(w, r, &requestScope{
+			GroupVersion: groupVersion,
+			Resource:     delegate.APIResource,
+			Storage:      delegate.storage,
+			FieldManager: delegate.fieldManager,
+			Namespace:    namespace,
+			Name:         name,
+		})
 	}
 }
 
 // Cluster: /apis/tomlebreux.com/v1/<resource>
 func (as *APIServer) resourceHandler(groupVersion schema.GroupVersion) https.ErrorHandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		resource, err := splitResource(r.URL.Path)
+		resource, name, err := splitResource(r.URL.Path)
 		if err != nil {
-			https.FourZeroFour(as.logger.WithError(err), w, r)
-			return nil
+			as.logger.WithError(err).Debug("Could not split path")
+			return errNotFound
 		}
 
 		gvr := schema.GroupVersionResource{
@@ -254,22 +293,33 @@ func (as *APIServer) resourceHandler(groupVersion schema.GroupVersion) https.Err
 		}
 		delegate, ok := as.delegates[gvr]
 		if !ok {
-			https.FourZeroFour(as.logger, w, r)
-			return nil
-		}
-
-		if err := delegate(w, r, namespace); err != nil {
-			return err
+			// Namespaced resources can be listed and watched across all
+			// namespaces
+			delegate, ok = as.namespacedDelegates[gvr]
+			if !ok || name != "" || r.Method != http.MethodGet {
+				return errNotFound
+			}
 		}
 
-		return nil
+		return as.serveResource(w, r, &requestScope{
+			GroupVersion: groupVersion,
+			Resource:     delegate.APIResource,
+			Storage:      delegate.storage,
+			FieldManager: delegate.fieldManager,
+			Name:         name,
+		})
 	}
 }
 
 // addSerializedHandler sets up a handler than will send the serialised content
 // to the specified path.
 func (as *APIServer) addSerializedHandler(pattern string, m k8sruntime.Object) {
-	as.mux.HandleFunc(pattern, https.ErrorHTTPHandler(as.logger, func(w http.ResponseWriter, r *http.Request) error {
+	as.mux.HandleFunc(pattern, as.serializedHandler(m))
+}
+
+// serializedHandler returns a handler that sends the serialised content.
+func (as *APIServer) serializedHandler(m k8sruntime.Object) http.HandlerFunc {
+	return as.errorHTTPHandler(unversionedVersion, func(w http.ResponseWriter, r *http.Request) error {
 		if r.Method == http.MethodGet {
 			info, err := AcceptedSerializer(r, Codecs)
 			if err != nil {
@@ -280,14 +330,33 @@ func (as *APIServer) addSerializedHandler(pattern string, m k8sruntime.Object) {
 			w.Header().Set(ContentTypeHeader, info.MediaType)
 			err = Codecs.EncoderForVersion(info.Serializer, unversionedVersion).Encode(shallowCopy, w)
 			if err != nil {
-				return errors.New("error marshalling")
+				return apierrors.NewInternalError(errors.Wrap(err, "error marshalling"))
 			}
 		} else {
-			https.FourZeroFour(as.logger, w, r)
+			return apierrors.NewMethodNotSupported(schema.GroupResource{}, r.Method)
 		}
 
 		return nil
-	}))
+	})
+}
+
+// errorHTTPHandler returns an http.HandlerFunc that writes the error returned
+// by the handler, if any, as a Status in the content type negotiated with the
+// client. Errors that are not already a Status are internal errors.
+func (as *APIServer) errorHTTPHandler(groupVersion schema.GroupVersion, f https.ErrorHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := f(w, r)
+		if err == nil {
+			return
+		}
+
+		if _, ok := err.(apierrors.APIStatus); !ok {
+			runtime.HandleError(https.LogRequest(as.logger, r), err)
+			err = apierrors.NewInternalError(err)
+		}
+		code := responsewriters.ErrorNegotiated(err, Codecs, groupVersion, w, r)
+		https.LogRequest(as.logger, r).WithError(err).WithField("code", code).Debug("Request failed")
+	}
 }
 
 // shallowCopyObjectForTargetKind ensures obj is unique by performing a shallow copy
@@ -303,7 +372,7 @@ func shallowCopyObjectForTargetKind(obj k8sruntime.Object) k8sruntime.Object {
 // AcceptedSerializer takes the request, and returns a serialiser (if it exists)
 // for the given codec factory and
 // for the Accepted media types.  If not found, returns error
-func AcceptedSerializer(r *http.Request, codecs serializer.CodecFactory) (k8sruntime.SerializerInfo, error) {
+func AcceptedSerializer(r *http.Request, codecs k8sruntime.NegotiatedSerializer) (k8sruntime.SerializerInfo, error) {
 	// this is so we know what we can accept
 	mediaTypes := codecs.SupportedMediaTypes()
 	alternatives := make([]string, len(mediaTypes))
@@ -313,38 +382,87 @@ func AcceptedSerializer(r *http.Request, codecs serializer.CodecFactory) (k8srun
 	header := r.Header.Get(AcceptHeader)
 	accept := goautoneg.Negotiate(header, alternatives)
 	if accept == "" {
+		if header != "" {
+			return k8sruntime.SerializerInfo{}, negotiation.NewNotAcceptableError(alternatives)
+		}
 		accept = k8sruntime.ContentTypeJSON
 	}
 	info, ok := k8sruntime.SerializerInfoForMediaType(mediaTypes, accept)
 	if !ok {
-		return info, errors.Errorf("Could not find serializer for Accept: %s", header)
+		return info, negotiation.NewNotAcceptableError(alternatives)
+	}
+
+	return info, nil
+}
+
+// ContentTypeSerializer takes the request, and returns a serialiser (if it exists)
+// for the given codec factory and
+// for the Content-Type of the request body.  If not found, returns error
+func ContentTypeSerializer(r *http.Request, codecs k8sruntime.NegotiatedSerializer) (k8sruntime.SerializerInfo, error) {
+	supported, _ := negotiation.MediaTypesForSerializer(codecs)
+	contentType, _, err := mime.ParseMediaType(r.Header.Get(ContentTypeHeader))
+	if err != nil {
+		return k8sruntime.SerializerInfo{}, negotiation.NewUnsupportedMediaTypeError(supported)
+	}
+	info, ok := k8sruntime.SerializerInfoForMediaType(codecs.SupportedMediaTypes(), contentType)
+	if !ok {
+		return info, negotiation.NewUnsupportedMediaTypeError(supported)
 	}
 
 	return info, nil
 }
 
-// splitNameSpaceResource returns the namespace and the type of resource
-func splitNamespaceResource(path string) (string, string, error) {
+// withoutProtobuf is a NegotiatedSerializer that doesn't support protobuf.
+type withoutProtobuf struct {
+	k8sruntime.NegotiatedSerializer
+}
+
+func (s withoutProtobuf) SupportedMediaTypes() []k8sruntime.SerializerInfo {
+	var mediaTypes []k8sruntime.SerializerInfo
+	for _, info := range s.NegotiatedSerializer.SupportedMediaTypes() {
+		if info.MediaType != k8sruntime.ContentTypeProtobuf {
+			mediaTypes = append(mediaTypes, info)
+		}
+	}
+	return mediaTypes
+}
+
+// splitNameSpaceResource returns the namespace, the type of resource and the
+// name of the resource, which is empty for collection requests
+// Namespaced: /apis/tomlebreux.com/v1/namespaces/<namespace>/<resource>[/<name>]
+func splitNamespaceResource(path string) (string, string, string, error) {
 	list := strings.Split(strings.Trim(path, "/"), "/")
-	if len(list) < 4 {
-		return "", "", errors.Errorf("could not find namespace and resource in path: %s", path)
+	if len(list) < 6 || len(list) > 7 {
+		return "", "", "", errors.Errorf("could not find namespace and resource in path: %s", path)
 	}
 	last := list[3:]
 
 	if last[0] != "namespaces" {
-		return "", "", errors.Errorf("wrong format in path: %s", path)
+		return "", "", "", errors.Errorf("wrong format in path: %s", path)
 	}
 
-	return last[1], last[2], nil
+	name := ""
+	if len(last) == 4 {
+		name = last[3]
+	}
+
+	return last[1], last[2], name, nil
 }
 
-// Cluster: /apis/tomlebreux.com/v1/<resource>
-func splitResource(path string) (string, error) {
+// splitResource returns the type of resource and the name of the resource,
+// which is empty for collection requests
+// Cluster: /apis/tomlebreux.com/v1/<resource>[/<name>]
+func splitResource(path string) (string, string, error) {
 	list := strings.Split(strings.Trim(path, "/"), "/")
-	if len(list) < 4 {
-		return "", errors.Errorf("could not find resource in path: %s", path)
+	if len(list) < 4 || len(list) > 5 {
+		return "", "", errors.Errorf("could not find resource in path: %s", path)
+	}
+	last := list[3:]
+
+	name := ""
+	if len(last) == 2 {
+		name = last[1]
 	}
-	last := list[len(list)-1:]
 
-	return last[0], nil
+	return last[0], name, nil
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	wcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/authentication"
+)
+
+// tokenLookupIndex indexes the Secrets backing tokens by the
+// tokenLookupHash of their token.
+const tokenLookupIndex = "tomlebreux.com/token-lookup"
+
+// tokenAuthenticator authenticates the tokens presented in TokenReviews as
+// the user of the RancherToken or ClusterRancherToken they belong to.
+type tokenAuthenticator struct {
+	secretCache wcorev1.SecretCache
+}
+
+// newTokenAuthenticator indexes the Secrets of the informer by lookup hash,
+// so it must be called before the informer is started.
+func newTokenAuthenticator(secretClient wcorev1.SecretController) *tokenAuthenticator {
+	secretCache := secretClient.Cache()
+	secretCache.AddIndexer(tokenLookupIndex, func(secret *corev1.Secret) ([]string, error) {
+		lookupHash := secret.Data[lookupHashKey]
+		if secret.Labels[tokenKindLabel] == "" || len(lookupHash) == 0 {
+			return nil, nil
+		}
+		return []string{string(lookupHash)}, nil
+	})
+	return &tokenAuthenticator{secretCache: secretCache}
+}
+
+// Handle authenticates the token of the TokenReview. Disabled and expired
+// tokens are unauthenticated.
+func (a *tokenAuthenticator) Handle(_ context.Context, req authentication.Request) authentication.Response {
+	token, err := a.lookup(req.Spec.Token)
+	if err != nil {
+		return authentication.Errored(err)
+	}
+	switch {
+	case token == nil:
+		return authentication.Unauthenticated("invalid token", authenticationv1.UserInfo{})
+	case !token.Spec.Enabled:
+		return authentication.Unauthenticated("token is disabled", authenticationv1.UserInfo{})
+	case token.Status.Expired:
+		return authentication.Unauthenticated("token has expired", authenticationv1.UserInfo{})
+	}
+	return authentication.Authenticated("", authenticationv1.UserInfo{
+		Username: token.Spec.UserID,
+	})
+}
+
+// lookup returns the token matching the presented token, or nil if there is
+// none.
+func (a *tokenAuthenticator) lookup(presented string) (*RancherToken, error) {
+	secrets, err := a.secretCache.GetByIndex(tokenLookupIndex, tokenLookupHash(presented))
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range secrets {
+		token, err := tokenFromSecret(secret)
+		if err != nil {
+			slog.Error("Could not read token", "namespace", secret.Namespace, "name", secret.Name, "error", err)
+			continue
+		}
+		ok, err := verifyToken(presented, token.Status.HashedToken)
+		if err != nil {
+			slog.Error("Could not verify token", "namespace", secret.Namespace, "name", secret.Name, "error", err)
+			continue
+		}
+		if ok {
+			return token, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/authorization/authorizerfactory"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	webhookutil "k8s.io/apiserver/pkg/util/webhook"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
+)
+
+const (
+	// defaultAllowCacheTTL and defaultDenyCacheTTL are how long the results
+	// of SubjectAccessReviews are cached, like the defaults of extension
+	// apiservers
+	defaultAllowCacheTTL = 10 * time.Second
+	defaultDenyCacheTTL  = 10 * time.Second
+
+	// clusterGroup and clusterResource are the resource of the clusters
+	// tokens can be scoped to
+	clusterGroup    = "management.cattle.io"
+	clusterResource = "clusters"
+)
+
+// newSubjectAccessReviewAuthorizer returns the authorizer that asks the
+// kube-apiserver whether users are allowed to do something with
+// SubjectAccessReviews. Allowed and denied results are cached for the given
+// TTLs.
+func newSubjectAccessReviewAuthorizer(client authorizationv1client.AuthorizationV1Interface, allowCacheTTL, denyCacheTTL time.Duration) (authorizer.Authorizer, error) {
+	backoff := webhookutil.DefaultRetryBackoffWithInitialDelay(500 * time.Millisecond)
+	return authorizerfactory.DelegatingAuthorizerConfig{
+		SubjectAccessReviewClient: client,
+		AllowCacheTTL:             allowCacheTTL,
+		DenyCacheTTL:              denyCacheTTL,
+		WebhookRetryBackoff:       &backoff,
+	}.New()
+}
+
+// authorize returns Forbidden unless the user of the request is allowed the
+// attributes. Everything is allowed without an authorizer, ie: requests are
+// only authorized by the aggregator.
+func authorize(ctx context.Context, auth authorizer.Authorizer, attrs authorizer.AttributesRecord) error {
+	if auth == nil {
+		return nil
+	}
+	u, ok := genericapirequest.UserFrom(ctx)
+	if !ok {
+		return apierrors.NewInternalError(errors.New("no user in the context of the request"))
+	}
+	attrs.User = u
+
+	decision, reason, err := auth.Authorize(ctx, attrs)
+	switch {
+	case decision == authorizer.DecisionAllow:
+		return nil
+	case err != nil:
+		return apierrors.NewInternalError(err)
+	}
+
+	message := forbiddenMessage(attrs)
+	if reason != "" {
+		message += ": " + reason
+	}
+	resource := schema.GroupResource{Group: attrs.APIGroup, Resource: attrs.Resource}
+	return apierrors.NewForbidden(resource, attrs.Name, errors.New(message))
+}
+
+// forbiddenMessage is the message of the kube-apiserver for the attributes.
+func forbiddenMessage(attrs authorizer.AttributesRecord) string {
+	resource := attrs.Resource
+	if attrs.Subresource != "" {
+		resource += "/" + attrs.Subresource
+	}
+	scope := "at the cluster scope"
+	if attrs.Namespace != "" {
+		scope = fmt.Sprintf("in the namespace %q", attrs.Namespace)
+	}
+	return fmt.Sprintf("User %q cannot %s resource %q in API group %q %s", attrs.User.GetName(), attrs.Verb, resource, attrs.APIGroup, scope)
+}
+
+// requestAttributes returns the attributes of a request against the resource
+// of the scope.
+func requestAttributes(r *http.Request, scope *requestScope) authorizer.AttributesRecord {
+	return authorizer.AttributesRecord{
+		Verb:            requestVerb(r, scope),
+		Namespace:       scope.Namespace,
+		APIGroup:        scope.GroupVersion.Group,
+		APIVersion:      scope.GroupVersion.Version,
+		Resource:        scope.Resource.Name,
+		Name:            scope.Name,
+		ResourceRequest: true,
+		Path:            r.URL.Path,
+	}
+}
+
+// requestVerb returns the verb of the request, as RBAC knows it.
+func requestVerb(r *http.Request, scope *requestScope) string {
+	switch r.Method {
+	case http.MethodGet:
+		if scope.Name != "" {
+			return "get"
+		}
+		if watch := r.URL.Query().Get("watch"); watch == "true" || watch == "1" {
+			return "watch"
+		}
+		return "list"
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return ""
+}
+
+// accessReviewer answers questions about what the user of a request can do
+// beyond the request itself, eg: whether they can impersonate the user of a
+// token they create.
+type accessReviewer struct {
+	authorizer authorizer.Authorizer
+}
+
+// canImpersonateUser returns Forbidden unless the user of the request can
+// impersonate the user.
+func (r *accessReviewer) canImpersonateUser(ctx context.Context, name string) error {
+	return authorize(ctx, r.authorizer, authorizer.AttributesRecord{
+		Verb:            "impersonate",
+		Resource:        "users",
+		Name:            name,
+		ResourceRequest: true,
+	})
+}
+
+// canAccessCluster returns Forbidden unless the user of the request can get
+// the cluster.
+func (r *accessReviewer) canAccessCluster(ctx context.Context, name string) error {
+	return authorize(ctx, r.authorizer, authorizer.AttributesRecord{
+		Verb:            "get",
+		APIGroup:        clusterGroup,
+		Resource:        clusterResource,
+		Name:            name,
+		ResourceRequest: true,
+	})
+}
+
+// checkToken returns Forbidden if the user of the request can't write the
+// token. Creating a token for another user requires impersonating them, and
+// scoping a token to a cluster requires access to the cluster. old is nil for
+// creates.
+func (r *accessReviewer) checkToken(ctx context.Context, token, old *RancherToken) error {
+	if r.authorizer == nil {
+		return nil
+	}
+	u, ok := genericapirequest.UserFrom(ctx)
+	if !ok {
+		return apierrors.NewInternalError(errors.New("no user in the context of the request"))
+	}
+	if old == nil && token.Spec.UserID != "" && token.Spec.UserID != u.GetName() {
+		if err := r.canImpersonateUser(ctx, token.Spec.UserID); err != nil {
+			return err
+		}
+	}
+	if token.Spec.ClusterName != "" && (old == nil || old.Spec.ClusterName != token.Spec.ClusterName) {
+		if err := r.canAccessCluster(ctx, token.Spec.ClusterName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ttlV1alpha1Annotation is the annotation of internal tokens with the TTL
+// of a v1alpha1 token, when it isn't written the way v1alpha1 writes TTLs, eg:
+// "1h". It's only set by conversions, and never returned to clients.
+const ttlV1alpha1Annotation = "tomlebreux.com/v1alpha1-ttl"
+
+// conversionFunc returns a function that registers fn in the scheme to
+// convert between the types of its arguments.
+func conversionFunc[A, B any](fn func(*A, *B) error) func(*runtime.Scheme) error {
+	return func(scheme *runtime.Scheme) error {
+		return scheme.AddConversionFunc((*A)(nil), (*B)(nil), func(a, b interface{}, _ conversion.Scope) error {
+			return fn(a.(*A), b.(*B))
+		})
+	}
+}
+
+// v1alpha1
+
+func convertRancherTokenV1alpha1ToInternal(in *RancherTokenV1alpha1, out *RancherToken) error {
+	out.ObjectMeta = in.ObjectMeta
+	out.Status = RancherTokenStatus(in.Status)
+	if err := convertRancherTokenSpecV1alpha1ToInternal(&in.Spec, &out.Spec); err != nil {
+		return err
+	}
+	out.Annotations = ttlV1alpha1Annotations(in.Annotations, in.Spec.TTL, out.Spec.TTL.Duration)
+	return nil
+}
+
+func convertRancherTokenInternalToV1alpha1(in *RancherToken, out *RancherTokenV1alpha1) error {
+	out.ObjectMeta = in.ObjectMeta
+	out.Status = RancherTokenStatusV1alpha1(in.Status)
+	if err := convertRancherTokenSpecInternalToV1alpha1(&in.Spec, &out.Spec); err != nil {
+		return err
+	}
+	out.Spec.TTL = ttlV1alpha1(in.Annotations, out.Spec.TTL, in.Spec.TTL.Duration)
+	out.Annotations = withoutAnnotation(in.Annotations, ttlV1alpha1Annotation)
+	return nil
+}
+
+func convertRancherTokenListV1alpha1ToInternal(in *RancherTokenListV1alpha1, out *RancherTokenList) error {
+	out.ListMeta = in.ListMeta
+	out.Items = make([]RancherToken, len(in.Items))
+	for i := range in.Items {
+		if err := convertRancherTokenV1alpha1ToInternal(&in.Items[i], &out.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertRancherTokenListInternalToV1alpha1(in *RancherTokenList, out *RancherTokenListV1alpha1) error {
+	out.ListMeta = in.ListMeta
+	out.Items = make([]RancherTokenV1alpha1, len(in.Items))
+	for i := range in.Items {
+		if err := convertRancherTokenInternalToV1alpha1(&in.Items[i], &out.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertClusterRancherTokenV1alpha1ToInternal(in *ClusterRancherTokenV1alpha1, out *ClusterRancherToken) error {
+	out.ObjectMeta = in.ObjectMeta
+	out.Status = RancherTokenStatus(in.Status)
+	if err := convertRancherTokenSpecV1alpha1ToInternal(&in.Spec, &out.Spec); err != nil {
+		return err
+	}
+	out.Annotations = ttlV1alpha1Annotations(in.Annotations, in.Spec.TTL, out.Spec.TTL.Duration)
+	return nil
+}
+
+func convertClusterRancherTokenInternalToV1alpha1(in *ClusterRancherToken, out *ClusterRancherTokenV1alpha1) error {
+	out.ObjectMeta = in.ObjectMeta
+	out.Status = RancherTokenStatusV1alpha1(in.Status)
+	if err := convertRancherTokenSpecInternalToV1alpha1(&in.Spec, &out.Spec); err != nil {
+		return err
+	}
+	out.Spec.TTL = ttlV1alpha1(in.Annotations, out.Spec.TTL, in.Spec.TTL.Duration)
+	out.Annotations = withoutAnnotation(in.Annotations, ttlV1alpha1Annotation)
+	return nil
+}
+
+func convertClusterRancherTokenListV1alpha1ToInternal(in *ClusterRancherTokenListV1alpha1, out *ClusterRancherTokenList) error {
+	out.ListMeta = in.ListMeta
+	out.Items = make([]ClusterRancherToken, len(in.Items))
+	for i := range in.Items {
+		if err := convertClusterRancherTokenV1alpha1ToInternal(&in.Items[i], &out.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertClusterRancherTokenListInternalToV1alpha1(in *ClusterRancherTokenList, out *ClusterRancherTokenListV1alpha1) error {
+	out.ListMeta = in.ListMeta
+	out.Items = make([]ClusterRancherTokenV1alpha1, len(in.Items))
+	for i := range in.Items {
+		if err := convertClusterRancherTokenInternalToV1alpha1(&in.Items[i], &out.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertRancherTokenSpecV1alpha1ToInternal parses the TTL and Enabled
+// strings of v1alpha1. An empty TTL means the token doesn't expire.
+func convertRancherTokenSpecV1alpha1ToInternal(in *RancherTokenSpecV1alpha1, out *RancherTokenSpec) error {
+	out.UserID = in.UserID
+	out.ClusterName = in.ClusterName
+
+	ttl, err := parseTTLV1alpha1(in.TTL)
+	if err != nil {
+		return field.Invalid(field.NewPath("spec", "ttl"), in.TTL, "must be a duration, such as 1h30m, or a number of seconds")
+	}
+	out.TTL = metav1.Duration{Duration: ttl}
+
+	switch in.Enabled {
+	case "true":
+		out.Enabled = true
+	case "false", "":
+		out.Enabled = false
+	default:
+		return field.NotSupported(field.NewPath("spec", "enabled"), in.Enabled, []string{"true", "false"})
+	}
+	return nil
+}
+
+// convertRancherTokenSpecInternalToV1alpha1 writes the TTL as a number of
+// seconds unless it isn't a whole number of seconds. The TTL of the
+// ttlV1alpha1Annotation is written by the conversions of the tokens.
+func convertRancherTokenSpecInternalToV1alpha1(in *RancherTokenSpec, out *RancherTokenSpecV1alpha1) error {
+	out.UserID = in.UserID
+	out.ClusterName = in.ClusterName
+
+	out.TTL = formatTTLV1alpha1(in.TTL.Duration)
+	out.Enabled = strconv.FormatBool(in.Enabled)
+	return nil
+}
+
+func formatTTLV1alpha1(ttl time.Duration) string {
+	switch {
+	case ttl == 0:
+		return ""
+	case ttl%time.Second == 0:
+		return strconv.FormatInt(int64(ttl/time.Second), 10)
+	default:
+		return ttl.String()
+	}
+}
+
+func parseTTLV1alpha1(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return 0, nil
+	}
+
+	var d time.Duration
+	if seconds, err := strconv.ParseInt(ttl, 10, 64); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if d, err = time.ParseDuration(ttl); err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, strconv.ErrRange
+	}
+	return d, nil
+}
+
+// ttlV1alpha1Annotations returns the annotations of the internal token of a
+// v1alpha1 token, with the ttlV1alpha1Annotation if its TTL isn't written the
+// way v1alpha1 writes ttl.
+func ttlV1alpha1Annotations(annotations map[string]string, ttl string, d time.Duration) map[string]string {
+	if ttl == formatTTLV1alpha1(d) {
+		return withoutAnnotation(annotations, ttlV1alpha1Annotation)
+	}
+	out := make(map[string]string, len(annotations)+1)
+	for k, v := range annotations {
+		out[k] = v
+	}
+	out[ttlV1alpha1Annotation] = ttl
+	return out
+}
+
+// ttlV1alpha1 returns the TTL of the ttlV1alpha1Annotation, unless the TTL
+// changed since it was set.
+func ttlV1alpha1(annotations map[string]string, ttl string, d time.Duration) string {
+	annotated, ok := annotations[ttlV1alpha1Annotation]
+	if !ok {
+		return ttl
+	}
+	if parsed, err := parseTTLV1alpha1(annotated); err != nil || parsed != d {
+		return ttl
+	}
+	return annotated
+}
+
+// withoutAnnotation returns a copy of the annotations without the key, or the
+// annotations if they don't have it.
+func withoutAnnotation(annotations map[string]string, key string) map[string]string {
+	if _, ok := annotations[key]; !ok {
+		return annotations
+	}
+	if len(annotations) == 1 {
+		return nil
+	}
+	out := make(map[string]string, len(annotations)-1)
+	for k, v := range annotations {
+		if k != key {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// v1beta1
+
+func convertRancherTokenV1beta1ToInternal(in *RancherTokenV1beta1, out *RancherToken) error {
+	out.ObjectMeta = in.ObjectMeta
+	out.Spec = RancherTokenSpec(in.Spec)
+	out.Status = RancherTokenStatus(in.Status)
+	return nil
+}
+
+func convertRancherTokenInternalToV1beta1(in *RancherToken, out *RancherTokenV1beta1) error {
+	out.ObjectMeta = in.ObjectMeta
+	out.Annotations = withoutAnnotation(in.Annotations, ttlV1alpha1Annotation)
+	out.Spec = RancherTokenSpecV1beta1(in.Spec)
+	out.Status = RancherTokenStatusV1beta1(in.Status)
+	return nil
+}
+
+func convertRancherTokenListV1beta1ToInternal(in *RancherTokenListV1beta1, out *RancherTokenList) error {
+	out.ListMeta = in.ListMeta
+	out.Items = make([]RancherToken, len(in.Items))
+	for i := range in.Items {
+		if err := convertRancherTokenV1beta1ToInternal(&in.Items[i], &out.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertRancherTokenListInternalToV1beta1(in *RancherTokenList, out *RancherTokenListV1beta1) error {
+	out.ListMeta = in.ListMeta
+	out.Items = make([]RancherTokenV1beta1, len(in.Items))
+	for i := range in.Items {
+		if err := convertRancherTokenInternalToV1beta1(&in.Items[i], &out.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertClusterRancherTokenV1beta1ToInternal(in *ClusterRancherTokenV1beta1, out *ClusterRancherToken) error {
+	out.ObjectMeta = in.ObjectMeta
+	out.Spec = RancherTokenSpec(in.Spec)
+	out.Status = RancherTokenStatus(in.Status)
+	return nil
+}
+
+func convertClusterRancherTokenInternalToV1beta1(in *ClusterRancherToken, out *ClusterRancherTokenV1beta1) error {
+	out.ObjectMeta = in.ObjectMeta
+	out.Annotations = withoutAnnotation(in.Annotations, ttlV1alpha1Annotation)
+	out.Spec = RancherTokenSpecV1beta1(in.Spec)
+	out.Status = RancherTokenStatusV1beta1(in.Status)
+	return nil
+}
+
+func convertClusterRancherTokenListV1beta1ToInternal(in *ClusterRancherTokenListV1beta1, out *ClusterRancherTokenList) error {
+	out.ListMeta = in.ListMeta
+	out.Items = make([]ClusterRancherToken, len(in.Items))
+	for i := range in.Items {
+		if err := convertClusterRancherTokenV1beta1ToInternal(&in.Items[i], &out.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func convertClusterRancherTokenListInternalToV1beta1(in *ClusterRancherTokenList, out *ClusterRancherTokenListV1beta1) error {
+	out.ListMeta = in.ListMeta
+	out.Items = make([]ClusterRancherTokenV1beta1, len(in.Items))
+	for i := range in.Items {
+		if err := convertClusterRancherTokenInternalToV1beta1(&in.Items[i], &out.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
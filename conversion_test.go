@@ -0,0 +1,162 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	fuzz "github.com/google/gofuzz"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	"k8s.io/apimachinery/pkg/api/apitesting/roundtrip"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+)
+
+// fuzzerFuncs fuzz the TTL of the internal spec, which can't be negative.
+func fuzzerFuncs(_ serializer.CodecFactory) []interface{} {
+	return []interface{}{
+		func(spec *RancherTokenSpec, c fuzz.Continue) {
+			c.FuzzNoCustom(spec)
+			switch c.Intn(3) {
+			case 0:
+				spec.TTL = metav1.Duration{}
+			case 1:
+				spec.TTL = metav1.Duration{Duration: time.Duration(c.Int31()) * time.Second}
+			default:
+				spec.TTL = metav1.Duration{Duration: time.Duration(c.Int63())}
+			}
+		},
+	}
+}
+
+func installFunc(scheme *runtime.Scheme) {
+	if err := AddToScheme(scheme); err != nil {
+		panic(err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	roundtrip.RoundTripTestForAPIGroup(t, installFunc, fuzzerFuncs)
+}
+
+// TestConvertBetweenVersions converts tokens from v1alpha1 to v1beta1 and back
+// through the internal version.
+func TestConvertBetweenVersions(t *testing.T) {
+	scheme := runtime.NewScheme()
+	installFunc(scheme)
+	f := fuzzer.FuzzerFor(
+		fuzzer.MergeFuzzerFuncs(metafuzzer.Funcs, fuzzerFuncs),
+		rand.NewSource(rand.Int63()),
+		serializer.NewCodecFactory(scheme),
+	)
+
+	tests := []struct {
+		name     string
+		internal func() runtime.Object
+		versions []runtime.Object
+	}{
+		{
+			name:     "RancherToken",
+			internal: func() runtime.Object { return &RancherToken{} },
+			versions: []runtime.Object{&RancherTokenV1alpha1{}, &RancherTokenV1beta1{}, &RancherTokenV1alpha1{}},
+		},
+		{
+			name:     "ClusterRancherToken",
+			internal: func() runtime.Object { return &ClusterRancherToken{} },
+			versions: []runtime.Object{&ClusterRancherTokenV1alpha1{}, &ClusterRancherTokenV1beta1{}, &ClusterRancherTokenV1alpha1{}},
+		},
+		{
+			name:     "RancherTokenList",
+			internal: func() runtime.Object { return &RancherTokenList{} },
+			versions: []runtime.Object{&RancherTokenListV1alpha1{}, &RancherTokenListV1beta1{}, &RancherTokenListV1alpha1{}},
+		},
+		{
+			name:     "ClusterRancherTokenList",
+			internal: func() runtime.Object { return &ClusterRancherTokenList{} },
+			versions: []runtime.Object{&ClusterRancherTokenListV1alpha1{}, &ClusterRancherTokenListV1beta1{}, &ClusterRancherTokenListV1alpha1{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				original := tt.internal()
+				f.Fuzz(original)
+
+				obj := original.DeepCopyObject()
+				for _, version := range tt.versions {
+					external := version.DeepCopyObject()
+					if err := scheme.Convert(obj, external, nil); err != nil {
+						t.Fatal(err)
+					}
+					obj = tt.internal()
+					if err := scheme.Convert(external, obj, nil); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if !apiequality.Semantic.DeepEqual(original, obj) {
+					t.Fatalf("the conversions altered the object: %s", cmp.Diff(original, obj))
+				}
+			}
+		})
+	}
+}
+
+// TestConvertTTLV1alpha1 converts v1alpha1 tokens to the internal version and
+// back, keeping their TTL as it was written.
+func TestConvertTTLV1alpha1(t *testing.T) {
+	tests := []struct {
+		ttl     string
+		wantTTL time.Duration
+	}{
+		{ttl: "", wantTTL: 0},
+		{ttl: "3600", wantTTL: time.Hour},
+		{ttl: "1h", wantTTL: time.Hour},
+		{ttl: "1h30m", wantTTL: 90 * time.Minute},
+		{ttl: "1.5s", wantTTL: 1500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ttl, func(t *testing.T) {
+			original := &RancherTokenV1alpha1{Spec: RancherTokenSpecV1alpha1{UserID: "bob", TTL: tt.ttl, Enabled: "true"}}
+			internal := &RancherToken{}
+			if err := Scheme.Convert(original, internal, nil); err != nil {
+				t.Fatal(err)
+			}
+			if internal.Spec.TTL.Duration != tt.wantTTL {
+				t.Errorf("got internal ttl %s, want %s", internal.Spec.TTL.Duration, tt.wantTTL)
+			}
+
+			v1alpha1 := &RancherTokenV1alpha1{}
+			if err := Scheme.Convert(internal, v1alpha1, nil); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(original, v1alpha1); diff != "" {
+				t.Errorf("the conversions altered the token: %s", diff)
+			}
+
+			v1beta1 := &RancherTokenV1beta1{}
+			if err := Scheme.Convert(internal, v1beta1, nil); err != nil {
+				t.Fatal(err)
+			}
+			if v1beta1.Annotations != nil {
+				t.Errorf("got v1beta1 annotations %v, want none", v1beta1.Annotations)
+			}
+		})
+	}
+
+	// The annotation is ignored once the TTL changed
+	internal := &RancherToken{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{ttlV1alpha1Annotation: "1h"}},
+		Spec:       RancherTokenSpec{TTL: metav1.Duration{Duration: 2 * time.Hour}},
+	}
+	v1alpha1 := &RancherTokenV1alpha1{}
+	if err := Scheme.Convert(internal, v1alpha1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v1alpha1.Spec.TTL != "7200" {
+		t.Errorf("got ttl %q, want %q", v1alpha1.Spec.TTL, "7200")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sort"
+
+	apidiscoveryv2 "k8s.io/api/apidiscovery/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/apiserver/pkg/endpoints"
+)
+
+// groupVersionPriority is the priority of a group version in discovery, as
+// set in the APIService of the group version.
+type groupVersionPriority struct {
+	GroupPriorityMinimum int32
+	VersionPriority      int32
+}
+
+// SetGroupVersionPriority sets the priorities of the group version in
+// discovery. Versions with a higher VersionPriority are listed first, and the
+// first one is the preferred version of the group. They should match the
+// APIService of the group version.
+func (as *APIServer) SetGroupVersionPriority(groupVersion schema.GroupVersion, groupPriorityMinimum, versionPriority int32) {
+	as.priorities[groupVersion] = groupVersionPriority{
+		GroupPriorityMinimum: groupPriorityMinimum,
+		VersionPriority:      versionPriority,
+	}
+
+	as.updateGroups()
+	if _, ok := as.resourceList[groupVersion]; ok {
+		as.updateAggregatedDiscovery(groupVersion)
+	}
+}
+
+// updateGroups rebuilds the APIGroup of each group, and the APIGroupList
+// served at /apis, from the group versions in resourceList. Versions are
+// sorted by priority, then by the Kubernetes ordering (v1 > v1beta1 >
+// v1alpha1), and the first one is the preferred version of the group.
+func (as *APIServer) updateGroups() {
+	versions := map[string][]metav1.GroupVersionForDiscovery{}
+	for groupVersion := range as.resourceList {
+		versions[groupVersion.Group] = append(versions[groupVersion.Group], metav1.GroupVersionForDiscovery{
+			GroupVersion: groupVersion.String(),
+			Version:      groupVersion.Version,
+		})
+	}
+
+	names := make([]string, 0, len(as.groups))
+	for name, group := range as.groups {
+		groupVersions := versions[name]
+		sort.Slice(groupVersions, func(i, j int) bool {
+			iPriority := as.priorities[schema.GroupVersion{Group: name, Version: groupVersions[i].Version}].VersionPriority
+			jPriority := as.priorities[schema.GroupVersion{Group: name, Version: groupVersions[j].Version}].VersionPriority
+			if iPriority != jPriority {
+				return iPriority > jPriority
+			}
+			return version.CompareKubeAwareVersionStrings(groupVersions[i].Version, groupVersions[j].Version) > 0
+		})
+
+		group.Versions = groupVersions
+		group.PreferredVersion = metav1.GroupVersionForDiscovery{}
+		if len(groupVersions) > 0 {
+			group.PreferredVersion = groupVersions[0]
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	as.groupList.Groups = make([]metav1.APIGroup, 0, len(names))
+	for _, name := range names {
+		as.groupList.Groups = append(as.groupList.Groups, *as.groups[name])
+	}
+}
+
+// updateAggregatedDiscovery replaces the group version in the aggregated
+// discovery document with the resources registered in resourceList. The
+// document and its ETag are recomputed on the next request.
+func (as *APIServer) updateAggregatedDiscovery(groupVersion schema.GroupVersion) {
+	resources, err := endpoints.ConvertGroupVersionIntoToDiscovery(as.resourceList[groupVersion].APIResources)
+	if err != nil {
+		as.logger.WithError(err).WithField("groupversion", groupVersion).Error("Could not convert resources for aggregated discovery")
+		return
+	}
+	// Resources don't set their group version, which is the one they are
+	// registered under
+	for i := range resources {
+		resources[i].ResponseKind.Group = groupVersion.Group
+		resources[i].ResponseKind.Version = groupVersion.Version
+	}
+
+	as.discovery.AddGroupVersion(groupVersion.Group, apidiscoveryv2.APIVersionDiscovery{
+		Version:   groupVersion.Version,
+		Resources: resources,
+		Freshness: apidiscoveryv2.DiscoveryFreshnessCurrent,
+	})
+
+	// The priority is ignored for group versions that the resource manager
+	// doesn't know about yet
+	if priority, ok := as.priorities[groupVersion]; ok {
+		as.discovery.SetGroupVersionPriority(metav1.GroupVersion(groupVersion), int(priority.GroupPriorityMinimum), int(priority.VersionPriority))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+
+	wcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apiserver/pkg/util/dryrun"
+)
+
+// secretWriter returns the client that the Secrets backing tokens are
+// written with. For dry-run requests, writes are checked like the
+// kube-apiserver would, but nothing is persisted.
+func secretWriter(client wcorev1.SecretController, dryRun []string) wcorev1.SecretController {
+	if dryrun.IsDryRun(dryRun) {
+		return &dryRunSecretClient{SecretController: client}
+	}
+	return client
+}
+
+// dryRunSecretClient reads Secrets with the client it wraps, and returns the
+// Secret that would have been written for writes.
+type dryRunSecretClient struct {
+	wcorev1.SecretController
+}
+
+func (c *dryRunSecretClient) Create(secret *corev1.Secret) (*corev1.Secret, error) {
+	_, err := c.Get(secret.Namespace, secret.Name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		return nil, apierrors.NewAlreadyExists(corev1.Resource("secrets"), secret.Name)
+	case !apierrors.IsNotFound(err):
+		return nil, err
+	}
+	// Like the kube-apiserver, dry-run creates return the uid and
+	// creationTimestamp the Secret would have had
+	secret = secret.DeepCopy()
+	secret.UID = uuid.NewUUID()
+	secret.CreationTimestamp = metav1.Now()
+	return secret, nil
+}
+
+func (c *dryRunSecretClient) Update(secret *corev1.Secret) (*corev1.Secret, error) {
+	current, err := c.Get(secret.Namespace, secret.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if secret.ResourceVersion != "" && secret.ResourceVersion != current.ResourceVersion {
+		return nil, apierrors.NewConflict(corev1.Resource("secrets"), secret.Name, errors.New(optimisticLockErrorMsg))
+	}
+	return secret, nil
+}
+
+func (c *dryRunSecretClient) Delete(namespace, name string, _ *metav1.DeleteOptions) error {
+	_, err := c.Get(namespace, name, metav1.GetOptions{})
+	return err
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	wcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// setTokenExpiry sets when the token expires from its TTL, and whether it
+// has expired by now. Tokens without a TTL don't expire. The expiry of
+// tokens that aren't created yet (eg: dry-run creates) is relative to now.
+func setTokenExpiry(token *RancherToken, now time.Time) {
+	token.Status.ExpiresAt = nil
+	token.Status.Expired = false
+	if token.Spec.TTL.Duration <= 0 {
+		return
+	}
+
+	created := token.CreationTimestamp.Time
+	if created.IsZero() {
+		created = now
+	}
+	expiresAt := created.Add(token.Spec.TTL.Duration)
+	token.Status.ExpiresAt = &metav1.Time{Time: expiresAt}
+	token.Status.Expired = !now.Before(expiresAt)
+}
+
+// expiryConfig is the configuration of the tokenExpiryController.
+type expiryConfig struct {
+	// disableGracePeriod is how long after they expire tokens are disabled
+	disableGracePeriod time.Duration
+	// deleteGracePeriod is how long after they expire tokens are deleted.
+	// Expired tokens are never deleted when nil.
+	deleteGracePeriod *time.Duration
+}
+
+// tokenExpiryController disables, then deletes, the tokens that expired once
+// their grace period is over. Tokens that haven't expired yet are enqueued
+// again for when they do.
+type tokenExpiryController struct {
+	ctx     context.Context
+	secrets wcorev1.SecretController
+	tokens  map[string]*tokenStorage
+	config  expiryConfig
+}
+
+// registerTokenExpiryController registers the controller on the Secrets
+// backing the tokens of the given storages.
+func registerTokenExpiryController(ctx context.Context, secrets wcorev1.SecretController, config expiryConfig, storages ...*tokenStorage) {
+	c := &tokenExpiryController{
+		ctx:     ctx,
+		secrets: secrets,
+		tokens:  make(map[string]*tokenStorage, len(storages)),
+		config:  config,
+	}
+	for _, storage := range storages {
+		c.tokens[storage.kind] = storage
+	}
+	secrets.OnChange(ctx, "token-expiry", c.sync)
+}
+
+func (c *tokenExpiryController) sync(_ string, secret *corev1.Secret) (*corev1.Secret, error) {
+	if secret == nil || secret.DeletionTimestamp != nil {
+		return secret, nil
+	}
+	tokens, ok := c.tokens[secret.Labels[tokenKindLabel]]
+	if !ok {
+		return secret, nil
+	}
+
+	token, err := tokenFromSecret(secret)
+	if err != nil {
+		// Retrying won't fix the data of the Secret
+		slog.Error("Could not read token", "namespace", secret.Namespace, "name", secret.Name, "error", err)
+		return secret, nil
+	}
+	if token.Status.ExpiresAt == nil {
+		return secret, nil
+	}
+	now := time.Now()
+	expiresAt := token.Status.ExpiresAt.Time
+
+	if c.config.deleteGracePeriod != nil {
+		deleteAt := expiresAt.Add(*c.config.deleteGracePeriod)
+		if !now.Before(deleteAt) {
+			slog.Info("Deleting expired token", "kind", tokens.kind, "namespace", secret.Namespace, "name", secret.Name)
+			if err := tokens.delete(secret.Namespace, secret.Name, nil, nil); err != nil {
+				return nil, retryableError(err, "Could not delete expired token", tokens.kind, secret)
+			}
+			return nil, nil
+		}
+		c.secrets.EnqueueAfter(secret.Namespace, secret.Name, deleteAt.Sub(now))
+	}
+
+	if !token.Spec.Enabled {
+		return secret, nil
+	}
+	disableAt := expiresAt.Add(c.config.disableGracePeriod)
+	if now.Before(disableAt) {
+		c.secrets.EnqueueAfter(secret.Namespace, secret.Name, disableAt.Sub(now))
+		return secret, nil
+	}
+
+	slog.Info("Disabling expired token", "kind", tokens.kind, "namespace", secret.Namespace, "name", secret.Name)
+	disable := func(_ context.Context, old *RancherToken) (*RancherToken, error) {
+		// Don't recreate a token that was deleted meanwhile
+		if old.UID == "" {
+			return nil, apierrors.NewNotFound(tokens.resource, secret.Name)
+		}
+		old.Spec.Enabled = false
+		return old, nil
+	}
+	if _, _, err := tokens.update(c.ctx, secret.Namespace, secret.Name, disable, nil, nil, nil); err != nil {
+		return nil, retryableError(err, "Could not disable expired token", tokens.kind, secret)
+	}
+	return secret, nil
+}
+
+// retryableError returns the error if syncing the Secret again could succeed,
+// eg: on conflicts or when the kube-apiserver is unavailable. Other errors,
+// such as tokens that were deleted meanwhile or that are invalid, are logged
+// and nil is returned, so that the Secret isn't requeued.
+func retryableError(err error, msg string, kind string, secret *corev1.Secret) error {
+	switch {
+	case apierrors.IsNotFound(err), apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		slog.Error(msg, "kind", kind, "namespace", secret.Namespace, "name", secret.Name, "error", err)
+		return nil
+	}
+	return err
+}
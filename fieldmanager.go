@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"k8s.io/kube-openapi/pkg/builder3"
+	"k8s.io/kube-openapi/pkg/common"
+)
+
+// newFieldManager returns the FieldManager of the kind, which tracks the
+// managedFields of its objects and merges server-side apply patches. Fields
+// are typed with the OpenAPI v3 schema of the kind, so the config must name
+// kinds with their x-kubernetes-group-version-kind extension.
+func newFieldManager(config *common.OpenAPIV3Config, gvk schema.GroupVersionKind) (*managedfields.FieldManager, error) {
+	obj, err := Scheme.New(gvk)
+	if err != nil {
+		return nil, err
+	}
+	t := reflect.TypeOf(obj).Elem()
+
+	definitions, err := builder3.BuildOpenAPIDefinitionsForResources(config, t.PkgPath()+"."+t.Name())
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not build OpenAPI definitions of %s", gvk)
+	}
+	typeConverter, err := managedfields.NewTypeConverter(definitions, false)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not build type converter of %s", gvk)
+	}
+
+	hub := schema.GroupVersion{Group: gvk.Group, Version: k8sruntime.APIVersionInternal}
+	return managedfields.NewDefaultFieldManager(typeConverter, Scheme, Scheme, Scheme, gvk, hub, "", nil)
+}
+
+// fieldManagerTransform returns a TransformFunc that updates the
+// managedFields of the object for the changes made by the manager.
+func fieldManagerTransform(fieldManager *managedfields.FieldManager, manager string) TransformFunc {
+	return func(_ context.Context, newObj k8sruntime.Object, oldObj k8sruntime.Object) (k8sruntime.Object, error) {
+		return fieldManager.UpdateNoErrors(oldObj, newObj, manager), nil
+	}
+}
+
+// managerOrUserAgent returns the manager, or the prefix of the user agent
+// when the client didn't set one, like the kube-apiserver does.
+// eg: kubectl/v1.30.2 (linux/amd64) kubernetes/3968350 is kubectl
+func managerOrUserAgent(manager, userAgent string) string {
+	if manager != "" {
+		return manager
+	}
+
+	prefix := strings.Split(userAgent, "/")[0]
+	buf := bytes.NewBuffer(nil)
+	for _, r := range prefix {
+		if !unicode.IsPrint(r) {
+			continue
+		}
+		if buf.Len()+utf8.RuneLen(r) > validation.FieldManagerMaxLength {
+			break
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
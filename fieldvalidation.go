@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/warning"
+	kjson "sigs.k8s.io/json"
+	"sigs.k8s.io/yaml"
+)
+
+// fieldValidation returns the field validation directive of a request. Like
+// the kube-apiserver, unknown and duplicate fields are reported as warnings
+// when the client doesn't ask for a directive.
+func fieldValidation(directive string) string {
+	if directive == "" {
+		return metav1.FieldValidationWarn
+	}
+	return directive
+}
+
+// strictSerializer returns the serializer that reports unknown and duplicate
+// fields, unless the directive ignores them.
+func strictSerializer(info k8sruntime.SerializerInfo, directive string) k8sruntime.Serializer {
+	if directive == metav1.FieldValidationIgnore || info.StrictSerializer == nil {
+		return info.Serializer
+	}
+	return info.StrictSerializer
+}
+
+// handleDecodeError returns the error of a decode as a BadRequest. Strict
+// decoding errors are only returned for the Strict directive, and are added
+// as warnings to the response for the Warn directive.
+func handleDecodeError(ctx context.Context, err error, directive string) error {
+	strictErr, ok := k8sruntime.AsStrictDecodingError(err)
+	if !ok || directive == metav1.FieldValidationStrict {
+		return apierrors.NewBadRequest(err.Error())
+	}
+	if directive == metav1.FieldValidationWarn {
+		addStrictDecodingWarnings(ctx, strictErr.Errors())
+	}
+	return nil
+}
+
+// duplicatePatchFields returns the duplicate fields of a merge patch or a
+// strategic merge patch, which are lost once the patch is applied.
+func duplicatePatchFields(patch []byte, directive string) ([]error, error) {
+	if directive == metav1.FieldValidationIgnore {
+		return nil, nil
+	}
+
+	strictErrs, err := kjson.UnmarshalStrict(patch, &map[string]interface{}{}, kjson.DisallowDuplicateFields)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("error decoding patch: %v", err))
+	}
+	return strictErrs, nil
+}
+
+// validateApplyFields checks an apply patch for duplicate fields. Unknown
+// fields are always rejected by the field manager.
+func validateApplyFields(ctx context.Context, patch []byte, directive string) error {
+	if directive == metav1.FieldValidationIgnore {
+		return nil
+	}
+
+	if err := yaml.UnmarshalStrict(patch, &map[string]interface{}{}); err != nil {
+		if directive == metav1.FieldValidationStrict {
+			return apierrors.NewBadRequest(fmt.Sprintf("error strict decoding YAML: %v", err))
+		}
+		addStrictDecodingWarnings(ctx, []error{err})
+	}
+	return nil
+}
+
+// yamlErrorPrefixes prefix the errors of sigs.k8s.io/yaml that list one
+// unknown or duplicate field per line.
+var yamlErrorPrefixes = []string{
+	"error converting YAML to JSON: yaml: unmarshal errors:\n",
+	"yaml: unmarshal errors:\n",
+}
+
+// addStrictDecodingWarnings adds a Warning header to the response for each
+// unknown or duplicate field. Headers can't span lines, so YAML errors are
+// split in one warning per field.
+func addStrictDecodingWarnings(ctx context.Context, errs []error) {
+	for _, err := range errs {
+		for _, text := range yamlWarnings(err.Error()) {
+			warning.AddWarning(ctx, "", text)
+		}
+	}
+}
+
+func yamlWarnings(text string) []string {
+	for _, prefix := range yamlErrorPrefixes {
+		trimmed, ok := strings.CutPrefix(text, prefix)
+		if !ok {
+			continue
+		}
+		lines := strings.Split(trimmed, "\n")
+		for i := range lines {
+			lines[i] = strings.TrimSpace(lines[i])
+		}
+		return lines
+	}
+	return []string{text}
+}
@@ -0,0 +1,758 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"agones.dev/agones/pkg/util/https"
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metainternalversionscheme "k8s.io/apimachinery/pkg/apis/meta/internalversion/scheme"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer/streaming"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/endpoints/handlers/negotiation"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"sigs.k8s.io/yaml"
+)
+
+// defaultWatchTimeout is the minimum duration of a watch when the client
+// doesn't ask for a timeout.
+const defaultWatchTimeout = 30 * time.Minute
+
+// patchTypes are the content types accepted for PATCH requests.
+var patchTypes = []string{
+	string(types.JSONPatchType),
+	string(types.MergePatchType),
+	string(types.StrategicMergePatchType),
+	string(types.ApplyPatchType),
+}
+
+// requestScope describes the resource a request is made against.
+type requestScope struct {
+	GroupVersion schema.GroupVersion
+	Resource     metav1.APIResource
+	Storage      Storage
+	// FieldManager tracks the managedFields of the objects of the resource.
+	// It is nil if they aren't tracked.
+	FieldManager *managedfields.FieldManager
+	// Namespace is empty for cluster scoped resources
+	Namespace string
+	// Name is empty for requests made against the collection
+	Name string
+}
+
+// HubGroupVersion returns the internal version of the group, which storages
+// work with. Request bodies are converted to it, and responses from it.
+func (scope *requestScope) HubGroupVersion() schema.GroupVersion {
+	return schema.GroupVersion{Group: scope.GroupVersion.Group, Version: k8sruntime.APIVersionInternal}
+}
+
+// GroupResource returns the group and resource the request is made against.
+func (scope *requestScope) GroupResource() schema.GroupResource {
+	return scope.GroupVersion.WithResource(scope.Resource.Name).GroupResource()
+}
+
+// serveResource dispatches the request to the handler for its verb, based
+// on the http method and whether a name was given.
+func (as *APIServer) serveResource(w http.ResponseWriter, r *http.Request, scope *requestScope) error {
+	https.LogRequest(as.logger, r).WithField("resource", scope.Resource.Name).Info("Serving resource")
+
+	u, err := as.authenticate(r)
+	if err != nil {
+		return err
+	}
+	ctx := genericapirequest.WithUser(r.Context(), u)
+	if scope.Namespace != "" {
+		ctx = genericapirequest.WithNamespace(ctx, scope.Namespace)
+	}
+	r = r.WithContext(ctx)
+
+	if err := authorize(ctx, as.authorizer, requestAttributes(r, scope)); err != nil {
+		return err
+	}
+
+	if scope.Name == "" {
+		switch r.Method {
+		case http.MethodGet:
+			if lister, ok := scope.Storage.(Lister); ok {
+				return as.listResource(w, r, scope, lister)
+			}
+		case http.MethodPost:
+			if creater, ok := scope.Storage.(Creater); ok {
+				return as.createResource(w, r, scope, creater)
+			}
+		}
+	} else {
+		switch r.Method {
+		case http.MethodGet:
+			if getter, ok := scope.Storage.(Getter); ok {
+				return as.getResource(w, r, scope, getter)
+			}
+		case http.MethodPut:
+			if updater, ok := scope.Storage.(Updater); ok {
+				return as.updateResource(w, r, scope, updater)
+			}
+		case http.MethodPatch:
+			if patcher, ok := scope.Storage.(Patcher); ok {
+				return as.patchResource(w, r, scope, patcher)
+			}
+		case http.MethodDelete:
+			if deleter, ok := scope.Storage.(GracefulDeleter); ok {
+				return as.deleteResource(w, r, scope, deleter)
+			}
+		}
+	}
+
+	return apierrors.NewMethodNotSupported(scope.GroupResource(), strings.ToLower(r.Method))
+}
+
+// authenticate returns the user the request is made by. Requests that can't
+// be authenticated are Unauthorized.
+func (as *APIServer) authenticate(r *http.Request) (user.Info, error) {
+	if as.authenticator == nil {
+		return nil, apierrors.NewUnauthorized("Unauthorized")
+	}
+	resp, ok, err := as.authenticator.AuthenticateRequest(r)
+	if err != nil {
+		https.LogRequest(as.logger, r).WithError(err).Info("Could not authenticate request")
+	}
+	if err != nil || !ok {
+		return nil, apierrors.NewUnauthorized("Unauthorized")
+	}
+	return resp.User, nil
+}
+
+func (as *APIServer) getResource(w http.ResponseWriter, r *http.Request, scope *requestScope, getter Getter) error {
+	options := &metav1.GetOptions{}
+	if err := metainternalversionscheme.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, options); err != nil {
+		return apierrors.NewBadRequest(err.Error())
+	}
+
+	obj, err := getter.Get(r.Context(), scope.Name, options)
+	if err != nil {
+		return err
+	}
+
+	return writeObject(w, r, scope, http.StatusOK, obj)
+}
+
+func (as *APIServer) listResource(w http.ResponseWriter, r *http.Request, scope *requestScope, lister Lister) error {
+	options := &metav1.ListOptions{}
+	if err := metainternalversionscheme.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, options); err != nil {
+		return apierrors.NewBadRequest(err.Error())
+	}
+
+	if options.Watch {
+		watcher, ok := scope.Storage.(Watcher)
+		if !ok {
+			return apierrors.NewMethodNotSupported(scope.GroupResource(), "watch")
+		}
+		return as.watchResource(w, r, scope, watcher, options)
+	}
+
+	obj, err := lister.List(r.Context(), options)
+	if err != nil {
+		return err
+	}
+
+	return writeObject(w, r, scope, http.StatusOK, obj)
+}
+
+// watchResource streams the events of the watch until the client goes away,
+// the timeout is reached or the watch ends. Each event is a WatchEvent
+// framed for the negotiated serializer.
+func (as *APIServer) watchResource(w http.ResponseWriter, r *http.Request, scope *requestScope, watcher Watcher, options *metav1.ListOptions) error {
+	info, err := AcceptedSerializer(r, ResourceCodecs)
+	if err != nil {
+		return err
+	}
+	embedded := newWatchEmbeddedEncoder(r.Context(), scope, Codecs.EncoderForVersion(info.Serializer, scope.GroupVersion))
+
+	if groupVersion, tableInfo, ok := AcceptedTable(r); ok {
+		tableOptions, err := tableOptions(r)
+		if err != nil {
+			return err
+		}
+		info = tableInfo
+		embedded.encoder = Codecs.EncoderForVersion(info.Serializer, groupVersion)
+		embedded.table = groupVersion
+		embedded.tableOptions = tableOptions
+	}
+	if info.StreamSerializer == nil {
+		_, supported := negotiation.MediaTypesForSerializer(ResourceCodecs)
+		return negotiation.NewNotAcceptableError(supported)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return apierrors.NewInternalError(errors.Errorf("unable to start watch - can't get http.Flusher: %#v", w))
+	}
+
+	// Same as the default of the kube-apiserver, spread the timeouts so that
+	// watches don't all restart at once
+	timeout := time.Duration(float64(defaultWatchTimeout) * (rand.Float64() + 1.0))
+	if options.TimeoutSeconds != nil {
+		timeout = time.Duration(*options.TimeoutSeconds) * time.Second
+	}
+
+	watching, err := watcher.Watch(r.Context(), options)
+	if err != nil {
+		return err
+	}
+	defer watching.Stop()
+
+	mediaType := info.MediaType
+	if mediaType != k8sruntime.ContentTypeJSON {
+		mediaType += ";stream=watch"
+	}
+	encoder := streaming.NewEncoder(info.StreamSerializer.Framer.NewFrameWriter(w), Codecs.EncoderForVersion(info.StreamSerializer.Serializer, scope.GroupVersion))
+
+	w.Header().Set(ContentTypeHeader, mediaType)
+	w.Header().Set("Transfer-Encoding", "chunked")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	ch := watching.ResultChan()
+	buf := &bytes.Buffer{}
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-timer.C:
+			return nil
+		case event, ok := <-ch:
+			if !ok {
+				return nil
+			}
+
+			buf.Reset()
+			if err := embedded.Encode(event, buf); err != nil {
+				as.logger.WithError(err).Error("Could not encode watch event")
+				return nil
+			}
+			outEvent := &metav1.WatchEvent{
+				Type:   string(event.Type),
+				Object: k8sruntime.RawExtension{Raw: buf.Bytes()},
+			}
+			if err := encoder.Encode(outEvent); err != nil {
+				// client disconnect.
+				return nil
+			}
+
+			if len(ch) == 0 {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func (as *APIServer) createResource(w http.ResponseWriter, r *http.Request, scope *requestScope, creater Creater) error {
+	options := &metav1.CreateOptions{}
+	if err := metainternalversionscheme.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, options); err != nil {
+		return apierrors.NewBadRequest(err.Error())
+	}
+	if errs := validation.ValidateCreateOptions(options); len(errs) > 0 {
+		return apierrors.NewInvalid(schema.GroupKind{Group: metav1.GroupName, Kind: "CreateOptions"}, "", errs)
+	}
+
+	obj, err := decodeBody(r, scope, creater.New(), fieldValidation(options.FieldValidation))
+	if err != nil {
+		return err
+	}
+
+	if err := ensureObjectNamespace(scope, obj); err != nil {
+		return err
+	}
+
+	if scope.FieldManager != nil {
+		obj = scope.FieldManager.UpdateNoErrors(creater.New(), obj, managerOrUserAgent(options.FieldManager, r.UserAgent()))
+	}
+
+	if err := as.mutate(r.Context(), scope, obj, nil, admission.Create, options, options.DryRun); err != nil {
+		return err
+	}
+
+	result, err := creater.Create(r.Context(), obj, as.validateCreate(scope, options), options)
+	if err != nil {
+		return err
+	}
+
+	return writeObject(w, r, scope, http.StatusCreated, result)
+}
+
+func (as *APIServer) updateResource(w http.ResponseWriter, r *http.Request, scope *requestScope, updater Updater) error {
+	options := &metav1.UpdateOptions{}
+	if err := metainternalversionscheme.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, options); err != nil {
+		return apierrors.NewBadRequest(err.Error())
+	}
+	if errs := validation.ValidateUpdateOptions(options); len(errs) > 0 {
+		return apierrors.NewInvalid(schema.GroupKind{Group: metav1.GroupName, Kind: "UpdateOptions"}, "", errs)
+	}
+
+	obj, err := decodeBody(r, scope, updater.New(), fieldValidation(options.FieldValidation))
+	if err != nil {
+		return err
+	}
+
+	if err := ensureObjectNamespace(scope, obj); err != nil {
+		return err
+	}
+	if err := ensureObjectName(scope, obj); err != nil {
+		return err
+	}
+
+	var transformers []TransformFunc
+	if scope.FieldManager != nil {
+		transformers = append(transformers, fieldManagerTransform(scope.FieldManager, managerOrUserAgent(options.FieldManager, r.UserAgent())))
+	}
+	transformers = append(transformers, as.mutateUpdate(scope, options))
+
+	objInfo := DefaultUpdatedObjectInfo(obj, transformers...)
+	result, created, err := updater.Update(r.Context(), scope.Name, objInfo, as.validateCreate(scope, createOptions(options)), as.validateUpdate(scope, options), options)
+	if err != nil {
+		return err
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	return writeObject(w, r, scope, status, result)
+}
+
+func (as *APIServer) patchResource(w http.ResponseWriter, r *http.Request, scope *requestScope, patcher Patcher) error {
+	options := &metav1.PatchOptions{}
+	if err := metainternalversionscheme.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, options); err != nil {
+		return apierrors.NewBadRequest(err.Error())
+	}
+
+	contentType, _, err := mime.ParseMediaType(r.Header.Get(ContentTypeHeader))
+	if err != nil || !slices.Contains(patchTypes, contentType) {
+		return negotiation.NewUnsupportedMediaTypeError(patchTypes)
+	}
+	patchType := types.PatchType(contentType)
+	if patchType == types.ApplyPatchType && scope.FieldManager == nil {
+		return apierrors.NewBadRequest(fmt.Sprintf("server-side apply is not supported for %s", scope.GroupResource()))
+	}
+
+	if errs := validation.ValidatePatchOptions(options, patchType); len(errs) > 0 {
+		return apierrors.NewInvalid(schema.GroupKind{Group: metav1.GroupName, Kind: "PatchOptions"}, "", errs)
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		return apierrors.NewBadRequest(err.Error())
+	}
+
+	updateOptions := &metav1.UpdateOptions{
+		DryRun:          options.DryRun,
+		FieldManager:    options.FieldManager,
+		FieldValidation: options.FieldValidation,
+	}
+	objInfo := WrapUpdatedObjectInfo(&patchObjectInfo{
+		scope:     scope,
+		patchType: patchType,
+		patch:     patch,
+		options:   options,
+		manager:   managerOrUserAgent(options.FieldManager, r.UserAgent()),
+	}, as.mutateUpdate(scope, updateOptions))
+	result, created, err := patcher.Update(r.Context(), scope.Name, objInfo, as.validateCreate(scope, createOptions(updateOptions)), as.validateUpdate(scope, updateOptions), updateOptions)
+
+	// Applying an object that doesn't exist creates it, even when the
+	// storage doesn't create objects on update. Storages that do create
+	// them are NotFound for objects that exist but that the user can't see.
+	if creater, ok := scope.Storage.(Creater); ok && patchType == types.ApplyPatchType && apierrors.IsNotFound(err) && !allowCreateOnUpdate(scope.Storage) {
+		result, err = as.applyCreate(r.Context(), scope, creater, objInfo, updateOptions)
+		created = true
+	}
+	if err != nil {
+		return err
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	return writeObject(w, r, scope, status, result)
+}
+
+// allowCreateOnUpdate returns whether the updates of the storage create the
+// objects that don't exist.
+func allowCreateOnUpdate(storage Storage) bool {
+	updater, ok := storage.(CreateOnUpdater)
+	return ok && updater.AllowCreateOnUpdate()
+}
+
+// applyCreate creates the object of an apply patch, applied to an empty
+// object. It's mutated as a create by objInfo.
+func (as *APIServer) applyCreate(ctx context.Context, scope *requestScope, creater Creater, objInfo UpdatedObjectInfo, options *metav1.UpdateOptions) (k8sruntime.Object, error) {
+	obj, err := objInfo.UpdatedObject(ctx, creater.New())
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureObjectNamespace(scope, obj); err != nil {
+		return nil, err
+	}
+
+	createOptions := createOptions(options)
+	return creater.Create(ctx, obj, as.validateCreate(scope, createOptions), createOptions)
+}
+
+func (as *APIServer) deleteResource(w http.ResponseWriter, r *http.Request, scope *requestScope, deleter GracefulDeleter) error {
+	// kubectl sends the options in the body, others set them as parameters.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return apierrors.NewBadRequest(err.Error())
+	}
+	options := &metav1.DeleteOptions{}
+	if len(body) > 0 {
+		decoder := metainternalversionscheme.Codecs.UniversalDecoder(metav1.SchemeGroupVersion)
+		if err := k8sruntime.DecodeInto(decoder, body, options); err != nil {
+			return apierrors.NewBadRequest(err.Error())
+		}
+	} else if err := metainternalversionscheme.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, options); err != nil {
+		return apierrors.NewBadRequest(err.Error())
+	}
+	if errs := validation.ValidateDeleteOptions(options); len(errs) > 0 {
+		return apierrors.NewInvalid(schema.GroupKind{Group: metav1.GroupName, Kind: "DeleteOptions"}, "", errs)
+	}
+
+	obj, deleted, err := deleter.Delete(r.Context(), scope.Name, as.admitDelete(scope, options), options)
+	if err != nil {
+		return err
+	}
+
+	status := http.StatusOK
+	if !deleted {
+		status = http.StatusAccepted
+	}
+	if obj == nil {
+		obj = &metav1.Status{
+			Status: metav1.StatusSuccess,
+			Code:   int32(status),
+			Details: &metav1.StatusDetails{
+				Name: scope.Name,
+				Kind: scope.Resource.Kind,
+			},
+		}
+	}
+
+	return writeObject(w, r, scope, status, obj)
+}
+
+// patchObjectInfo applies a patch to the stored object, and updates its
+// managedFields. Patches other than apply patches are applied to the JSON of
+// the object in the version of the request.
+type patchObjectInfo struct {
+	scope     *requestScope
+	patchType types.PatchType
+	patch     []byte
+	options   *metav1.PatchOptions
+	// manager is the field manager of the request, or the prefix of its user
+	// agent
+	manager string
+}
+
+func (i *patchObjectInfo) UpdatedObject(ctx context.Context, oldObj k8sruntime.Object) (k8sruntime.Object, error) {
+	accessor, err := meta.Accessor(oldObj)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj k8sruntime.Object
+	switch i.patchType {
+	case types.ApplyPatchType:
+		obj, err = i.apply(ctx, oldObj)
+	case types.JSONPatchType, types.MergePatchType, types.StrategicMergePatchType:
+		// Storages that create objects on update give an empty object when
+		// it doesn't exist, which only apply patches can create
+		if accessor.GetUID() == "" {
+			return nil, apierrors.NewNotFound(i.scope.GroupResource(), i.scope.Name)
+		}
+		obj, err = i.patchJSON(ctx, oldObj)
+		if err == nil && i.scope.FieldManager != nil {
+			obj = i.scope.FieldManager.UpdateNoErrors(oldObj, obj, i.manager)
+		}
+	default:
+		return nil, negotiation.NewUnsupportedMediaTypeError(patchTypes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureObjectNamespace(i.scope, obj); err != nil {
+		return nil, err
+	}
+	if err := ensureObjectName(i.scope, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// apply merges the applied configuration with the stored object, which
+// fails on conflicts with the fields of other managers unless forced.
+func (i *patchObjectInfo) apply(ctx context.Context, oldObj k8sruntime.Object) (k8sruntime.Object, error) {
+	patchObj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	if err := yaml.Unmarshal(i.patch, &patchObj.Object); err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("error decoding YAML: %v", err))
+	}
+	if err := validateApplyFields(ctx, i.patch, fieldValidation(i.options.FieldValidation)); err != nil {
+		return nil, err
+	}
+
+	// Objects that don't exist are applied to an empty object of the version
+	// of the request, since the empty internal object may not convert to an
+	// empty one (eg: the enabled "false" of v1alpha1), which would give its
+	// fields to the before-first-apply manager
+	accessor, err := meta.Accessor(oldObj)
+	if err != nil {
+		return nil, err
+	}
+	if accessor.GetUID() == "" {
+		oldObj, err = Scheme.New(i.scope.GroupVersion.WithKind(i.scope.Resource.Kind))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	force := i.options.Force != nil && *i.options.Force
+	obj, err := i.scope.FieldManager.Apply(oldObj, patchObj, i.options.FieldManager, force)
+	if err != nil {
+		if _, ok := err.(apierrors.APIStatus); ok {
+			return nil, err
+		}
+		// The applied configuration can't be converted to the object, eg:
+		// an invalid duration
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	return obj, nil
+}
+
+// patchJSON applies a JSON patch (RFC 6902), a JSON merge patch (RFC 7386)
+// or a strategic merge patch to the stored object. Unknown fields are those
+// of the patched object.
+func (i *patchObjectInfo) patchJSON(ctx context.Context, oldObj k8sruntime.Object) (k8sruntime.Object, error) {
+	original, err := k8sruntime.Encode(Codecs.LegacyCodec(i.scope.GroupVersion), oldObj)
+	if err != nil {
+		return nil, err
+	}
+
+	directive := fieldValidation(i.options.FieldValidation)
+	var strictErrs []error
+	if i.patchType == types.MergePatchType || i.patchType == types.StrategicMergePatchType {
+		strictErrs, err = duplicatePatchFields(i.patch, directive)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var patched []byte
+	switch i.patchType {
+	case types.JSONPatchType:
+		patch, err := jsonpatch.DecodePatch(i.patch)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+		// The patch is well formed, but can't be applied, eg: a test
+		// operation failed
+		patched, err = patch.Apply(original)
+		if err != nil {
+			return nil, &apierrors.StatusError{ErrStatus: metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusUnprocessableEntity,
+				Reason:  metav1.StatusReasonInvalid,
+				Message: err.Error(),
+			}}
+		}
+	case types.MergePatchType:
+		patched, err = jsonpatch.MergePatch(original, i.patch)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+	case types.StrategicMergePatchType:
+		// The patch strategies are those of the struct of the version
+		versioned, err := Scheme.New(i.scope.GroupVersion.WithKind(i.scope.Resource.Kind))
+		if err != nil {
+			return nil, err
+		}
+		patched, err = strategicpatch.StrategicMergePatch(original, i.patch, versioned)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+	}
+
+	info, ok := k8sruntime.SerializerInfoForMediaType(Codecs.SupportedMediaTypes(), k8sruntime.ContentTypeJSON)
+	if !ok {
+		return nil, errors.New("no JSON serializer")
+	}
+	decoder := Codecs.DecoderToVersion(strictSerializer(info, directive), i.scope.HubGroupVersion())
+	obj, _, err := decoder.Decode(patched, nil, i.scope.Storage.New())
+	if err != nil {
+		strictErr, ok := k8sruntime.AsStrictDecodingError(err)
+		if !ok {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+		strictErrs = append(strictErrs, strictErr.Errors()...)
+	}
+	if len(strictErrs) > 0 {
+		if err := handleDecodeError(ctx, k8sruntime.NewStrictDecodingError(strictErrs), directive); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// decodeBody decodes the request body into into, using the serializer for
+// the request's content type. The body is converted from the version of the
+// request to the internal version. Unknown and duplicate fields are handled
+// according to the field validation directive.
+func decodeBody(r *http.Request, scope *requestScope, into k8sruntime.Object, directive string) (k8sruntime.Object, error) {
+	info, err := ContentTypeSerializer(r, ResourceCodecs)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+
+	defaultGVK := scope.GroupVersion.WithKind(scope.Resource.Kind)
+	decoder := Codecs.DecoderToVersion(strictSerializer(info, directive), scope.HubGroupVersion())
+	obj, _, err := decoder.Decode(body, &defaultGVK, into)
+	if err != nil {
+		if err := handleDecodeError(r.Context(), err, directive); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// ensureObjectNamespace defaults the namespace of obj to the namespace of the
+// request, and ensures they match. The namespace is cleared for cluster
+// scoped resources.
+func ensureObjectNamespace(scope *requestScope, obj k8sruntime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case accessor.GetNamespace() == scope.Namespace:
+	case accessor.GetNamespace() == "", scope.Namespace == "":
+		accessor.SetNamespace(scope.Namespace)
+	default:
+		return apierrors.NewBadRequest(fmt.Sprintf("the namespace of the object (%s) does not match the namespace on the request (%s)", accessor.GetNamespace(), scope.Namespace))
+	}
+
+	return nil
+}
+
+// ensureObjectName ensures that the name of obj is the name on the URL.
+func ensureObjectName(scope *requestScope, obj k8sruntime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if accessor.GetName() != scope.Name {
+		return apierrors.NewBadRequest(fmt.Sprintf("the name of the object (%s) does not match the name on the URL (%s)", accessor.GetName(), scope.Name))
+	}
+	return nil
+}
+
+// writeObject encodes obj with the serializer negotiated from the request's
+// Accept header. Objects are converted to a Table when the client asks for
+// one.
+func writeObject(w http.ResponseWriter, r *http.Request, scope *requestScope, status int, obj k8sruntime.Object) error {
+	if _, ok := obj.(*metav1.Status); !ok {
+		if groupVersion, info, ok := AcceptedTable(r); ok {
+			options, err := tableOptions(r)
+			if err != nil {
+				return err
+			}
+			table, err := asTable(r.Context(), scope, obj, options, groupVersion)
+			if err != nil {
+				return err
+			}
+
+			w.Header().Set(ContentTypeHeader, info.MediaType)
+			w.WriteHeader(status)
+			return Codecs.EncoderForVersion(info.Serializer, groupVersion).Encode(table, w)
+		}
+	}
+
+	info, err := AcceptedSerializer(r, ResourceCodecs)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set(ContentTypeHeader, info.MediaType)
+	w.WriteHeader(status)
+	return Codecs.EncoderForVersion(info.Serializer, scope.GroupVersion).Encode(obj, w)
+}
+
+// watchEmbeddedEncoder encodes the objects of watch events, converting them
+// to Tables when the client asks for one. Column definitions are only sent
+// with the first Table.
+type watchEmbeddedEncoder struct {
+	ctx          context.Context
+	scope        *requestScope
+	encoder      k8sruntime.Encoder
+	table        schema.GroupVersion
+	tableOptions *metav1.TableOptions
+}
+
+func newWatchEmbeddedEncoder(ctx context.Context, scope *requestScope, encoder k8sruntime.Encoder) *watchEmbeddedEncoder {
+	return &watchEmbeddedEncoder{
+		ctx:     ctx,
+		scope:   scope,
+		encoder: encoder,
+	}
+}
+
+func (e *watchEmbeddedEncoder) Encode(event watch.Event, w io.Writer) error {
+	obj := event.Object
+	if e.tableOptions != nil {
+		switch event.Type {
+		case watch.Error:
+		case watch.Bookmark:
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				return err
+			}
+			obj = &metav1.Table{ListMeta: metav1.ListMeta{ResourceVersion: accessor.GetResourceVersion()}}
+		default:
+			table, err := asTable(e.ctx, e.scope, obj, e.tableOptions, e.table)
+			if err != nil {
+				return err
+			}
+			e.tableOptions.NoHeaders = true
+			obj = table
+		}
+	}
+
+	return e.encoder.Encode(obj, w)
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+// testUserHeader is the header with the name of the user of test requests.
+const testUserHeader = "X-Test-User"
+
+// newTestAPIServer serves tokens backed by a fakeSecretClient. Requests are
+// authenticated as the user in the testUserHeader, and every request is
+// authorized.
+func newTestAPIServer(t *testing.T, owners ownerFilter) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	apiSrv := NewAPIServer(mux)
+	apiSrv.SetAuthenticator(authenticator.RequestFunc(func(r *http.Request) (*authenticator.Response, bool, error) {
+		name := r.Header.Get(testUserHeader)
+		if name == "" {
+			return nil, false, nil
+		}
+		return &authenticator.Response{User: &user.DefaultInfo{Name: name}}, true, nil
+	}))
+
+	secrets := newFakeSecretClient()
+	config := tokenConfig{
+		storageVersion: SchemeGroupVersionV1alpha1,
+		hashAlgorithm:  defaultHashAlgorithm,
+		owners:         owners,
+	}
+	rancherTokenStore := newRancherTokenStore(secrets, nil, config)
+	clusterRancherTokenStore := newClusterRancherTokenStore(secrets, nil, config)
+	for _, served := range servedVersions {
+		apiSrv.AddAPIResource(served.GroupVersion, metav1.APIResource{
+			Name:         "clusterranchertokens",
+			SingularName: "clusterranchertoken",
+			Kind:         "ClusterRancherToken",
+		}, clusterRancherTokenStore)
+		apiSrv.AddAPIResource(served.GroupVersion, metav1.APIResource{
+			Name:         "ranchertokens",
+			SingularName: "ranchertoken",
+			Namespaced:   true,
+			Kind:         "RancherToken",
+		}, rancherTokenStore)
+	}
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testRequest is a request of a test, and the response it expects.
+type testRequest struct {
+	method      string
+	path        string
+	user        string
+	contentType string
+	body        string
+	wantCode    int
+	// check, if set, checks the decoded response
+	check func(t *testing.T, obj map[string]interface{})
+}
+
+func (r testRequest) do(t *testing.T, server *httptest.Server) {
+	t.Helper()
+	req, err := http.NewRequest(r.method, server.URL+r.path, strings.NewReader(r.body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(testUserHeader, r.user)
+	req.Header.Set("Accept", "application/json")
+	if r.contentType != "" {
+		req.Header.Set(ContentTypeHeader, r.contentType)
+	} else if r.body != "" {
+		req.Header.Set(ContentTypeHeader, "application/json")
+	}
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != r.wantCode {
+		t.Fatalf("%s %s: got %d, want %d: %s", r.method, r.path, resp.StatusCode, r.wantCode, body)
+	}
+	if r.check != nil {
+		obj := map[string]interface{}{}
+		if err := json.Unmarshal(body, &obj); err != nil {
+			t.Fatalf("%s %s: %v: %s", r.method, r.path, err, body)
+		}
+		r.check(t, obj)
+	}
+}
+
+// jsonField returns the field of the object at the path, eg: metadata.name.
+func jsonField(obj map[string]interface{}, path string) interface{} {
+	var value interface{} = obj
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+const (
+	v1alpha1Tokens = "/apis/tomlebreux.com/v1alpha1/namespaces/default/ranchertokens"
+	v1beta1Tokens  = "/apis/tomlebreux.com/v1beta1/namespaces/default/ranchertokens"
+
+	applyPatch = "application/apply-patch+yaml"
+)
+
+func wantField(path string, want interface{}) func(*testing.T, map[string]interface{}) {
+	return func(t *testing.T, obj map[string]interface{}) {
+		t.Helper()
+		if got := jsonField(obj, path); got != want {
+			t.Errorf("got %s %v, want %v", path, got, want)
+		}
+	}
+}
+
+// wantManagers checks the managers of the managedFields of the object.
+func wantManagers(want ...string) func(*testing.T, map[string]interface{}) {
+	return func(t *testing.T, obj map[string]interface{}) {
+		t.Helper()
+		entries, _ := jsonField(obj, "metadata.managedFields").([]interface{})
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.(map[string]interface{})["manager"].(string))
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("got managers %v, want %v", got, want)
+		}
+	}
+}
+
+func checks(fns ...func(*testing.T, map[string]interface{})) func(*testing.T, map[string]interface{}) {
+	return func(t *testing.T, obj map[string]interface{}) {
+		t.Helper()
+		for _, fn := range fns {
+			fn(t, obj)
+		}
+	}
+}
+
+func TestTokenRequests(t *testing.T) {
+	createFoo := testRequest{
+		method:   http.MethodPost,
+		path:     v1alpha1Tokens,
+		user:     "bob",
+		body:     `{"metadata":{"name":"foo"},"spec":{"userID":"bob","enabled":"true"}}`,
+		wantCode: http.StatusCreated,
+	}
+
+	tests := []struct {
+		name     string
+		owners   ownerFilter
+		requests []testRequest
+	}{
+		{
+			name: "apply create v1alpha1",
+			requests: []testRequest{
+				{
+					method:      http.MethodPatch,
+					path:        v1alpha1Tokens + "/foo?fieldManager=kubectl",
+					user:        "bob",
+					contentType: applyPatch,
+					body:        "apiVersion: tomlebreux.com/v1alpha1\nkind: RancherToken\nmetadata:\n  name: foo\nspec:\n  userID: bob\n  enabled: \"true\"\n",
+					wantCode:    http.StatusCreated,
+					check:       checks(wantField("spec.enabled", "true"), wantManagers("kubectl")),
+				},
+			},
+		},
+		{
+			name: "apply create v1alpha1 without enabled",
+			requests: []testRequest{
+				{
+					method:      http.MethodPatch,
+					path:        v1alpha1Tokens + "/foo?fieldManager=kubectl",
+					user:        "bob",
+					contentType: applyPatch,
+					body:        "apiVersion: tomlebreux.com/v1alpha1\nkind: RancherToken\nmetadata:\n  name: foo\nspec:\n  userID: bob\n",
+					wantCode:    http.StatusCreated,
+					check:       wantManagers("kubectl"),
+				},
+			},
+		},
+		{
+			name: "apply create v1beta1",
+			requests: []testRequest{
+				{
+					method:      http.MethodPatch,
+					path:        v1beta1Tokens + "/foo?fieldManager=kubectl",
+					user:        "bob",
+					contentType: applyPatch,
+					body:        "apiVersion: tomlebreux.com/v1beta1\nkind: RancherToken\nmetadata:\n  name: foo\nspec:\n  userID: bob\n  ttl: 1h\n  enabled: true\n",
+					wantCode:    http.StatusCreated,
+					check:       checks(wantField("spec.enabled", true), wantField("spec.ttl", "1h0m0s"), wantManagers("kubectl")),
+				},
+			},
+		},
+		{
+			name: "apply invalid ttl",
+			requests: []testRequest{
+				{
+					method:      http.MethodPatch,
+					path:        v1beta1Tokens + "/foo?fieldManager=kubectl",
+					user:        "bob",
+					contentType: applyPatch,
+					body:        "apiVersion: tomlebreux.com/v1beta1\nkind: RancherToken\nmetadata:\n  name: foo\nspec:\n  userID: bob\n  ttl: \"90\"\n",
+					wantCode:    http.StatusBadRequest,
+				},
+			},
+		},
+		{
+			name:   "apply token of another user",
+			owners: ownerFilter{enabled: true},
+			requests: []testRequest{
+				createFoo,
+				{
+					method:      http.MethodPatch,
+					path:        v1alpha1Tokens + "/foo?fieldManager=kubectl",
+					user:        "alice",
+					contentType: applyPatch,
+					body:        "apiVersion: tomlebreux.com/v1alpha1\nkind: RancherToken\nmetadata:\n  name: foo\nspec:\n  userID: alice\n",
+					wantCode:    http.StatusNotFound,
+				},
+			},
+		},
+		{
+			name: "put without generation",
+			requests: []testRequest{
+				createFoo,
+				{
+					method:   http.MethodPut,
+					path:     v1alpha1Tokens + "/foo",
+					user:     "bob",
+					body:     `{"metadata":{"name":"foo"},"spec":{"userID":"bob","enabled":"false"}}`,
+					wantCode: http.StatusOK,
+					check:    checks(wantField("spec.enabled", "false"), wantField("metadata.generation", float64(2))),
+				},
+				{
+					method:   http.MethodPut,
+					path:     v1alpha1Tokens + "/foo",
+					user:     "bob",
+					body:     `{"metadata":{"name":"foo","labels":{"a":"b"}},"spec":{"userID":"bob","enabled":"false"}}`,
+					wantCode: http.StatusOK,
+					check:    wantField("metadata.generation", float64(2)),
+				},
+			},
+		},
+		{
+			name: "delete with stale preconditions",
+			requests: []testRequest{
+				createFoo,
+				{
+					method:   http.MethodPut,
+					path:     v1alpha1Tokens + "/foo",
+					user:     "bob",
+					body:     `{"metadata":{"name":"foo"},"spec":{"userID":"bob","enabled":"false"}}`,
+					wantCode: http.StatusOK,
+				},
+				{
+					method:   http.MethodDelete,
+					path:     v1alpha1Tokens + "/foo",
+					user:     "bob",
+					body:     `{"preconditions":{"resourceVersion":"1"}}`,
+					wantCode: http.StatusConflict,
+				},
+				{
+					method:   http.MethodDelete,
+					path:     v1alpha1Tokens + "/foo",
+					user:     "bob",
+					body:     `{"preconditions":{"uid":"another"}}`,
+					wantCode: http.StatusConflict,
+				},
+				{
+					method:   http.MethodDelete,
+					path:     v1alpha1Tokens + "/foo",
+					user:     "bob",
+					body:     `{"preconditions":{"resourceVersion":"2"}}`,
+					wantCode: http.StatusOK,
+				},
+			},
+		},
+		{
+			name: "v1alpha1 ttl",
+			requests: []testRequest{
+				{
+					method:   http.MethodPost,
+					path:     v1alpha1Tokens,
+					user:     "bob",
+					body:     `{"metadata":{"name":"foo"},"spec":{"userID":"bob","ttl":"1h"}}`,
+					wantCode: http.StatusCreated,
+					check:    wantField("spec.ttl", "1h"),
+				},
+				{
+					method:   http.MethodGet,
+					path:     v1alpha1Tokens + "/foo",
+					user:     "bob",
+					wantCode: http.StatusOK,
+					check:    checks(wantField("spec.ttl", "1h"), wantField("metadata.annotations", nil)),
+				},
+				{
+					method:   http.MethodGet,
+					path:     v1beta1Tokens + "/foo",
+					user:     "bob",
+					wantCode: http.StatusOK,
+					check:    checks(wantField("spec.ttl", "1h0m0s"), wantField("metadata.annotations", nil)),
+				},
+			},
+		},
+		{
+			name: "finalizers",
+			requests: []testRequest{
+				{
+					method:   http.MethodPost,
+					path:     v1alpha1Tokens,
+					user:     "bob",
+					body:     `{"metadata":{"name":"foo","finalizers":["example.com/foo"]},"spec":{"userID":"bob"}}`,
+					wantCode: http.StatusUnprocessableEntity,
+				},
+				createFoo,
+				{
+					method:   http.MethodPut,
+					path:     v1alpha1Tokens + "/foo",
+					user:     "bob",
+					body:     `{"metadata":{"name":"foo","ownerReferences":[{"apiVersion":"v1","kind":"ConfigMap","name":"foo","uid":"foo"}]},"spec":{"userID":"bob"}}`,
+					wantCode: http.StatusUnprocessableEntity,
+				},
+			},
+		},
+		{
+			name: "unsupported field selector",
+			requests: []testRequest{
+				{
+					method:   http.MethodGet,
+					path:     v1alpha1Tokens + "?fieldSelector=spec.userID%3Dbob",
+					user:     "bob",
+					wantCode: http.StatusBadRequest,
+				},
+			},
+		},
+		{
+			name: "dry-run create",
+			requests: []testRequest{
+				{
+					method:   http.MethodPost,
+					path:     v1alpha1Tokens + "?dryRun=All",
+					user:     "bob",
+					body:     `{"metadata":{"name":"foo"},"spec":{"userID":"bob"}}`,
+					wantCode: http.StatusCreated,
+					check: func(t *testing.T, obj map[string]interface{}) {
+						for _, path := range []string{"metadata.uid", "metadata.creationTimestamp", "status.plaintextToken"} {
+							if value, _ := jsonField(obj, path).(string); value == "" {
+								t.Errorf("got no %s", path)
+							}
+						}
+					},
+				},
+				{
+					method:   http.MethodGet,
+					path:     v1alpha1Tokens + "/foo",
+					user:     "bob",
+					wantCode: http.StatusNotFound,
+				},
+			},
+		},
+		{
+			name: "dry-run update",
+			requests: []testRequest{
+				createFoo,
+				{
+					method:   http.MethodPut,
+					path:     v1alpha1Tokens + "/foo?dryRun=All",
+					user:     "bob",
+					body:     `{"metadata":{"name":"foo"},"spec":{"userID":"bob","enabled":"false"}}`,
+					wantCode: http.StatusOK,
+					check:    wantField("spec.enabled", "false"),
+				},
+				{
+					method:   http.MethodGet,
+					path:     v1alpha1Tokens + "/foo",
+					user:     "bob",
+					wantCode: http.StatusOK,
+					check:    wantField("spec.enabled", "true"),
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestAPIServer(t, tt.owners)
+			for _, request := range tt.requests {
+				request.do(t, server)
+			}
+		})
+	}
+}
@@ -2,27 +2,31 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
-	"agones.dev/agones/pkg/util/https"
-	"agones.dev/agones/pkg/util/runtime"
 	"github.com/rancher/dynamiclistener"
 	"github.com/rancher/dynamiclistener/server"
 	wadmission "github.com/rancher/wrangler/v3/pkg/generated/controllers/admissionregistration.k8s.io"
 	wapiregistration "github.com/rancher/wrangler/v3/pkg/generated/controllers/apiregistration.k8s.io"
 	"github.com/rancher/wrangler/v3/pkg/generated/controllers/core"
-	wcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
 	"github.com/rancher/wrangler/v3/pkg/generic"
 	"github.com/rancher/wrangler/v3/pkg/kubeconfig"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
 )
 
@@ -33,8 +37,52 @@ const (
 	certName         = "cattle-apiextension-tls"
 	caName           = "cattle-apiextension-ca"
 	defaultHTTPSPort = 9443
+
+	// TODO: Verify what "good default" values should be
+	groupPriorityMinimum = 100
+
+	// storageVersionEnv is the environment variable with the version tokens
+	// are stored in, which defaults to v1alpha1
+	storageVersionEnv = "STORAGE_VERSION"
+
+	// hashAlgorithmEnv is the environment variable with the algorithm new
+	// tokens are hashed with, which defaults to defaultHashAlgorithm
+	hashAlgorithmEnv = "TOKEN_HASH_ALGORITHM"
+
+	// disableGracePeriodEnv is the environment variable with how long after
+	// they expire tokens are disabled, which defaults to 0
+	disableGracePeriodEnv = "TOKEN_DISABLE_GRACE_PERIOD"
+
+	// deleteGracePeriodEnv is the environment variable with how long after
+	// they expire tokens are deleted. Expired tokens aren't deleted when unset.
+	deleteGracePeriodEnv = "TOKEN_DELETE_GRACE_PERIOD"
+
+	// ownerFilterEnv is the environment variable that, when true, restricts
+	// the tokens users can see to their own
+	ownerFilterEnv = "TOKEN_OWNER_FILTER"
+
+	// ownerBypassGroupEnv is the environment variable with the group whose
+	// users see all tokens, which defaults to defaultOwnerBypassGroup
+	ownerBypassGroupEnv = "TOKEN_OWNER_BYPASS_GROUP"
+
+	// allowCacheTTLEnv and denyCacheTTLEnv are the environment variables
+	// with how long allowed and denied SubjectAccessReviews are cached, which
+	// default to defaultAllowCacheTTL and defaultDenyCacheTTL
+	allowCacheTTLEnv = "AUTHORIZATION_ALLOW_CACHE_TTL"
+	denyCacheTTLEnv  = "AUTHORIZATION_DENY_CACHE_TTL"
 )
 
+// servedVersions are the versions of the group served by the apiserver, and
+// the priority of each of them. The version with the highest priority is the
+// preferred version.
+var servedVersions = []struct {
+	GroupVersion    schema.GroupVersion
+	VersionPriority int32
+}{
+	{GroupVersion: SchemeGroupVersionV1beta1, VersionPriority: 15},
+	{GroupVersion: SchemeGroupVersionV1alpha1, VersionPriority: 10},
+}
+
 func must(err error) {
 	if err != nil {
 		panic(err)
@@ -56,85 +104,182 @@ func ptr[T any](t T) *T {
 	return &t
 }
 
+// CreateOrUpdate creates the object, or updates the existing object with
+// setFn. Updates are retried with the latest object on conflicts.
 func CreateOrUpdate[T generic.RuntimeMetaObject, TList k8sruntime.Object](
 	out T,
 	client generic.NonNamespacedControllerInterface[T, TList],
 	setFn func(T),
 ) error {
-	old, err := client.Get(out.GetName(), metav1.GetOptions{})
-	if err != nil {
-		if apierrors.IsNotFound(err) {
-			setFn(out)
-			_, err = client.Create(out)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		old, err := client.Get(out.GetName(), metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				setFn(out)
+				_, err = client.Create(out)
+			}
+			return err
+		} else {
+			setFn(old)
+			_, err = client.Update(old)
+			return err
 		}
-		return err
-	} else {
-		setFn(old)
-		_, err = client.Update(old)
-		return err
-	}
+	})
 }
 
+// CreateOrUpdateNamespaced is CreateOrUpdate for namespaced objects.
 func CreateOrUpdateNamespaced[T generic.RuntimeMetaObject, TList k8sruntime.Object](
 	out T,
 	client generic.ControllerInterface[T, TList],
 	setFn func(T),
 ) error {
-	old, err := client.Get(out.GetNamespace(), out.GetName(), metav1.GetOptions{})
-	if err != nil {
-		if apierrors.IsNotFound(err) {
-			setFn(out)
-			_, err = client.Create(out)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		old, err := client.Get(out.GetNamespace(), out.GetName(), metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				setFn(out)
+				_, err = client.Create(out)
+			}
+			return err
+		} else {
+			setFn(old)
+			_, err = client.Update(old)
+			return err
+		}
+	})
+}
+
+// storageVersionFromEnv returns the served version set in storageVersionEnv.
+func storageVersionFromEnv() (schema.GroupVersion, error) {
+	version := os.Getenv(storageVersionEnv)
+	if version == "" {
+		return SchemeGroupVersionV1alpha1, nil
+	}
+	for _, served := range servedVersions {
+		if served.GroupVersion.Version == version {
+			return served.GroupVersion, nil
 		}
-		return err
-	} else {
-		setFn(old)
-		_, err = client.Update(old)
-		return err
 	}
+	return schema.GroupVersion{}, fmt.Errorf("%s: %s is not a served version", storageVersionEnv, version)
 }
 
-func getSecretAndToken(secretClient wcorev1.SecretController, ns string, resourceName string) (*corev1.Secret, *RancherToken, error) {
-	secret, err := secretClient.Get(ns, resourceName, metav1.GetOptions{})
+// hashAlgorithmFromEnv returns the hash algorithm set in hashAlgorithmEnv.
+func hashAlgorithmFromEnv() (string, error) {
+	algorithm := os.Getenv(hashAlgorithmEnv)
+	if algorithm == "" {
+		return defaultHashAlgorithm, nil
+	}
+	if _, ok := hashAlgorithms[algorithm]; !ok {
+		return "", fmt.Errorf("%s: unsupported hash algorithm %s, must be one of %s", hashAlgorithmEnv, algorithm, strings.Join(hashAlgorithmNames(), ", "))
+	}
+	return algorithm, nil
+}
+
+// durationFromEnv returns the duration set in the environment variable, or
+// nil if it's unset.
+func durationFromEnv(env string) (*time.Duration, error) {
+	value := os.Getenv(env)
+	if value == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(value)
 	if err != nil {
-		return nil, nil, err
+		return nil, fmt.Errorf("%s: %w", env, err)
+	}
+	if d < 0 {
+		return nil, fmt.Errorf("%s: %s must not be negative", env, value)
 	}
+	return &d, nil
+}
 
-	token := &RancherToken{
-		ObjectMeta: secret.ObjectMeta,
-		Spec: RancherTokenSpec{
-			UserID:      string(secret.Data["userID"]),
-			ClusterName: string(secret.Data["clusterName"]),
-			TTL:         string(secret.Data["ttl"]),
-			Enabled:     string(secret.Data["enabled"]),
-		},
-		Status: RancherTokenStatus{
-			HashedToken: string(secret.Data["hashedToken"]),
-		},
+// expiryConfigFromEnv returns the grace periods of expired tokens set in
+// disableGracePeriodEnv and deleteGracePeriodEnv.
+func expiryConfigFromEnv() (expiryConfig, error) {
+	var config expiryConfig
+	disableGracePeriod, err := durationFromEnv(disableGracePeriodEnv)
+	if err != nil {
+		return config, err
+	}
+	if disableGracePeriod != nil {
+		config.disableGracePeriod = *disableGracePeriod
 	}
-	return secret, token, nil
+	config.deleteGracePeriod, err = durationFromEnv(deleteGracePeriodEnv)
+	if err != nil {
+		return config, err
+	}
+	return config, nil
 }
 
-func secretFromToken(token *RancherToken) *corev1.Secret {
-	secret := &corev1.Secret{
-		ObjectMeta: token.ObjectMeta,
-		StringData: make(map[string]string),
-		Data:       make(map[string][]byte),
+// ownerFilterFromEnv returns the owner filter set in ownerFilterEnv and
+// ownerBypassGroupEnv.
+func ownerFilterFromEnv() (ownerFilter, error) {
+	filter := ownerFilter{bypassGroup: defaultOwnerBypassGroup}
+	if value := os.Getenv(ownerFilterEnv); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("%s: %w", ownerFilterEnv, err)
+		}
+		filter.enabled = enabled
+	}
+	if group := os.Getenv(ownerBypassGroupEnv); group != "" {
+		filter.bypassGroup = group
+	}
+	return filter, nil
+}
+
+// cacheTTLsFromEnv returns how long SubjectAccessReviews are cached, set in
+// allowCacheTTLEnv and denyCacheTTLEnv.
+func cacheTTLsFromEnv() (time.Duration, time.Duration, error) {
+	allowCacheTTL, denyCacheTTL := defaultAllowCacheTTL, defaultDenyCacheTTL
+	allow, err := durationFromEnv(allowCacheTTLEnv)
+	if err != nil {
+		return 0, 0, err
+	}
+	if allow != nil {
+		allowCacheTTL = *allow
+	}
+	deny, err := durationFromEnv(denyCacheTTLEnv)
+	if err != nil {
+		return 0, 0, err
+	}
+	if deny != nil {
+		denyCacheTTL = *deny
 	}
-	secret.StringData["userID"] = token.Spec.UserID
-	secret.StringData["clusterName"] = token.Spec.ClusterName
-	secret.StringData["ttl"] = token.Spec.TTL
-	secret.StringData["hashedToken"] = token.Status.HashedToken
-	secret.StringData["enabled"] = token.Spec.Enabled
-	return secret
+	return allowCacheTTL, denyCacheTTL, nil
 }
 
 func main() {
 	ctx := context.Background()
 
+	storageVersion, err := storageVersionFromEnv()
+	must(err)
+
+	hashAlgorithm, err := hashAlgorithmFromEnv()
+	must(err)
+	owners, err := ownerFilterFromEnv()
+	must(err)
+	config := tokenConfig{
+		storageVersion: storageVersion,
+		hashAlgorithm:  hashAlgorithm,
+		owners:         owners,
+	}
+
+	expiry, err := expiryConfigFromEnv()
+	must(err)
+
 	restConfig, err := kubeconfig.GetNonInteractiveClientConfig(os.Getenv("KUBECONFIG")).ClientConfig()
 	must(err)
 
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	must(err)
+
+	// Authorize requests, and the fields of tokens, with SubjectAccessReviews
+	allowCacheTTL, denyCacheTTL, err := cacheTTLsFromEnv()
+	must(err)
+	sarAuthorizer, err := newSubjectAccessReviewAuthorizer(kubeClient.AuthorizationV1(), allowCacheTTL, denyCacheTTL)
+	must(err)
+	config.authorizer = sarAuthorizer
+
 	coreFactory, err := core.NewFactoryFromConfig(restConfig)
 	must(err)
 
@@ -150,6 +295,14 @@ func main() {
 	apiServiceClient := factory.Apiregistration().V1().APIService()
 	secretClient := coreFactory.Core().V1().Secret()
 
+	// ClusterRancherTokens are stored in their own namespace
+	_, err = coreFactory.Core().V1().Namespace().Create(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterTokenNamespace},
+	})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		must(err)
+	}
+
 	// Update the APIService resource when the TLS CA changes
 	coreFactory.Core().V1().Secret().OnChange(ctx, "update-api-service", func(name string, secret *corev1.Secret) (*corev1.Secret, error) {
 		if secret == nil || secret.Name != caName || secret.Namespace != namespace {
@@ -164,173 +317,74 @@ func main() {
 			return nil, err
 		}
 
-		it := &apiregistrationv1.APIService{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: fmt.Sprintf("%s.%s", SchemeGroupVersion.Version, SchemeGroupVersion.Group),
-			},
-		}
-		err = CreateOrUpdate(it, apiServiceClient, func(apiService *apiregistrationv1.APIService) {
-			apiService.Spec = apiregistrationv1.APIServiceSpec{
-				Service: &apiregistrationv1.ServiceReference{
-					Namespace: namespace,
-					Name:      serviceName,
-					Port:      ptr(int32(defaultHTTPSPort)),
+		for _, served := range servedVersions {
+			it := &apiregistrationv1.APIService{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: fmt.Sprintf("%s.%s", served.GroupVersion.Version, served.GroupVersion.Group),
 				},
-				CABundle: secret.Data[corev1.TLSCertKey],
-				Group:    SchemeGroupVersion.Group,
-				Version:  SchemeGroupVersion.Version,
-				// TODO: Verify what "good default" values should be
-				GroupPriorityMinimum: 100,
-				VersionPriority:      10,
 			}
-		})
-		if err != nil {
-			return nil, err
+			err = CreateOrUpdate(it, apiServiceClient, func(apiService *apiregistrationv1.APIService) {
+				apiService.Spec = apiregistrationv1.APIServiceSpec{
+					Service: &apiregistrationv1.ServiceReference{
+						Namespace: namespace,
+						Name:      serviceName,
+						Port:      ptr(int32(defaultHTTPSPort)),
+					},
+					CABundle:             secret.Data[corev1.TLSCertKey],
+					Group:                served.GroupVersion.Group,
+					Version:              served.GroupVersion.Version,
+					GroupPriorityMinimum: groupPriorityMinimum,
+					VersionPriority:      served.VersionPriority,
+				}
+			})
+			if err != nil {
+				return nil, err
+			}
 		}
 		return nil, nil
 	})
 
-	mux := http.DefaultServeMux
-	apiSrv := NewAPIServer(mux)
-
-	apiSrv.AddAPIResource(SchemeGroupVersion, metav1.APIResource{
-		Name:         "clusterranchertokens",
-		SingularName: "clusterranchertoken",
-		Namespaced:   false,
-		Kind:         "ClusterRancherToken",
-		Verbs: metav1.Verbs{
-			"create",
-			"list",
-		},
-	}, func(w http.ResponseWriter, req *http.Request, s string) error {
-		logger := runtime.NewLoggerWithType(s)
-		https.LogRequest(logger, req).Info("ClusterRancherTokens")
-		return nil
-	})
-
-	apiSrv.AddAPIResource(SchemeGroupVersion, metav1.APIResource{
-		Name:         "ranchertokens",
-		SingularName: "ranchertoken",
-		Namespaced:   true,
-		Kind:         "RancherToken",
-		Verbs: metav1.Verbs{
-			"create",
-			"get",
-		},
-	}, func(w http.ResponseWriter, req *http.Request, ns string) error {
-		logger := runtime.NewLoggerWithType(ns)
-		https.LogRequest(logger, req).Info("RancherTokens")
-
-		switch req.Method {
-		case "DELETE":
-			fields := strings.Split(req.URL.Path, "/")
-			resourceName := fields[len(fields)-1]
-			err := secretClient.Delete(ns, resourceName, &metav1.DeleteOptions{})
-			if err != nil {
-				return err
-			}
-			status := &metav1.Status{
-				Status: "Success",
-			}
-			info, err := AcceptedSerializer(req, Codecs)
-			must(err)
-			w.Header().Set("Content-Type", info.MediaType)
-			w.WriteHeader(http.StatusOK)
-			return Codecs.EncoderForVersion(info.Serializer, SchemeGroupVersion).Encode(status, w)
-		case "GET":
-			fields := strings.Split(req.URL.Path, "/")
-			resourceName := fields[len(fields)-1]
-
-			_, token, err := getSecretAndToken(secretClient, ns, resourceName)
-			if err != nil {
-				return err
-			}
-
-			info, err := AcceptedSerializer(req, Codecs)
-			must(err)
-			w.Header().Set("Content-Type", info.MediaType)
-			w.WriteHeader(http.StatusOK)
-			return Codecs.EncoderForVersion(info.Serializer, SchemeGroupVersion).Encode(token, w)
-		case "POST":
-			bytes, err := io.ReadAll(req.Body)
-			if err != nil {
-				return err
-			}
-
-			token := &RancherToken{}
-			_, _, err = Codecs.UniversalDecoder(SchemeGroupVersion).Decode(bytes, nil, token)
-			if err != nil {
-				return err
-			}
-
-			token.Status.PlaintextToken = "the-plaintext-token"
-			token.Status.HashedToken = "the-hashed-token"
-
-			secret := secretFromToken(token)
-			_, err = secretClient.Create(secret)
-			if err != nil {
-				return err
-			}
-
-			info, err := AcceptedSerializer(req, Codecs)
-			if err != nil {
-				return err
-			}
-			w.Header().Set("Content-Type", info.MediaType)
-			w.WriteHeader(http.StatusOK)
-			err = Codecs.EncoderForVersion(info.Serializer, SchemeGroupVersion).Encode(token, w)
-			if err != nil {
-				return err
-			}
-			return nil
-		case "PATCH":
-			if req.Header.Get("Content-Type") != "application/merge-patch+json" {
-				return fmt.Errorf("unsupported patch")
-			}
-
-			bytes, err := io.ReadAll(req.Body)
-			if err != nil {
-				return err
-			}
-			patchToken := &RancherToken{}
-			_, _, err = Codecs.UniversalDecoder(SchemeGroupVersion).Decode(bytes, nil, patchToken)
-			if err != nil {
-				return err
-			}
+	watchCache, err := newSecretWatchCache(secretClient.Informer())
+	must(err)
 
-			fields := strings.Split(req.URL.Path, "/")
-			resourceName := fields[len(fields)-1]
-			_, token, err := getSecretAndToken(secretClient, ns, resourceName)
-			if err != nil {
-				return err
-			}
-			token.Spec.Enabled = patchToken.Spec.Enabled
-			secret := secretFromToken(token)
-			err = CreateOrUpdateNamespaced(secret, secretClient, func(updated *corev1.Secret) {
-				updated.Data = secret.Data
-				updated.StringData = secret.StringData
-			})
-			fmt.Println("HITHERE", err)
-			if err != nil {
-				return err
-			}
+	// Authenticate the requests proxied by the aggregator with the front
+	// proxy configuration of the kube-apiserver
+	requestHeaderAuthenticator := newRequestHeaderAuthenticator()
+	coreFactory.Core().V1().ConfigMap().OnChange(ctx, "request-header-authentication", requestHeaderAuthenticator.sync)
 
-			info, err := AcceptedSerializer(req, Codecs)
-			if err != nil {
-				return err
-			}
-			w.Header().Set("Content-Type", info.MediaType)
-			w.WriteHeader(http.StatusOK)
-			err = Codecs.EncoderForVersion(info.Serializer, SchemeGroupVersion).Encode(token, w)
-			if err != nil {
-				return err
-			}
-			return nil
-		default:
-			return fmt.Errorf("unsupported request")
-		}
+	mux := http.DefaultServeMux
+	apiSrv := NewAPIServer(mux)
+	apiSrv.SetAuthenticator(requestHeaderAuthenticator)
+	apiSrv.SetAuthorizer(sarAuthorizer)
 
-	})
+	// Admit the tokens written with the admission webhooks of the cluster,
+	// which the kube-apiserver doesn't call for aggregated resources
+	informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
+	webhookAdmission, err := newWebhookAdmission(kubeClient, informerFactory, sarAuthorizer)
+	must(err)
+	apiSrv.SetAdmission(webhookAdmission)
+	informerFactory.Start(ctx.Done())
+
+	clusterRancherTokenStore := newClusterRancherTokenStore(secretClient, watchCache, config)
+	rancherTokenStore := newRancherTokenStore(secretClient, watchCache, config)
+	registerTokenExpiryController(ctx, secretClient, expiry, rancherTokenStore.tokens, clusterRancherTokenStore.tokens)
+	for _, served := range servedVersions {
+		apiSrv.AddAPIResource(served.GroupVersion, metav1.APIResource{
+			Name:         "clusterranchertokens",
+			SingularName: "clusterranchertoken",
+			Namespaced:   false,
+			Kind:         "ClusterRancherToken",
+		}, clusterRancherTokenStore)
+
+		apiSrv.AddAPIResource(served.GroupVersion, metav1.APIResource{
+			Name:         "ranchertokens",
+			SingularName: "ranchertoken",
+			Namespaced:   true,
+			Kind:         "RancherToken",
+		}, rancherTokenStore)
+
+		apiSrv.SetGroupVersionPriority(served.GroupVersion, groupPriorityMinimum, served.VersionPriority)
+	}
 
 	mux.HandleFunc("/", func(_ http.ResponseWriter, req *http.Request) {
 		bytes, err := io.ReadAll(req.Body)
@@ -338,7 +392,7 @@ func main() {
 		slog.Info("Received a request", "path", req.URL.Path, "method", req.Method, "body", string(bytes))
 	})
 
-	err = setupWebhook(mux)
+	err = setupWebhook(mux, secretClient)
 	must(err)
 
 	fmt.Println("Listening on ", defaultHTTPSPort)
@@ -349,7 +403,10 @@ func main() {
 		CertName:      certName,
 		CertNamespace: namespace,
 		TLSListenerConfig: dynamiclistener.Config{
-			SANs: []string{tlsName},
+			// The client certificate of the aggregator is verified by the
+			// requestHeaderAuthenticator on each request
+			TLSConfig: &tls.Config{ClientAuth: tls.RequestClientCert},
+			SANs:      []string{tlsName},
 			FilterCN: func(cns ...string) []string {
 				return []string{tlsName}
 			},
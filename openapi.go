@@ -1,29 +1,368 @@
 package main
 
 import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/endpoints"
+	"k8s.io/apiserver/pkg/endpoints/handlers/negotiation"
+	"k8s.io/apiserver/pkg/endpoints/openapi"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/kube-openapi/pkg/builder"
+	"k8s.io/kube-openapi/pkg/builder3"
 	"k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/common/restfuladapter"
+	"k8s.io/kube-openapi/pkg/handler"
 	"k8s.io/kube-openapi/pkg/validation/spec"
 )
 
-// This would be autogenerated from Go struct
-
-func getDefinitions(common.ReferenceCallback) map[string]common.OpenAPIDefinition {
-	return map[string]common.OpenAPIDefinition{
-		"main.RancherToken": common.OpenAPIDefinition{
-			Schema: spec.Schema{
-				SchemaProps: spec.SchemaProps{
-					Description: "Rancher token",
-					Type:        []string{"object"},
-					Properties: map[string]spec.Schema{
-						"foo": {
-							SchemaProps: spec.SchemaProps{
-								Description: "foo field",
-								Type:        []string{"string"},
-							},
-						},
-					},
-				},
-			},
-		},
+//go:generate go run k8s.io/kube-openapi/cmd/openapi-gen@v0.0.0-20240228011516-70dd3763d340 --output-file zz_generated.openapi.go --output-dir . --output-pkg main --go-header-file /dev/null --report-filename /dev/null apiserver-poc k8s.io/apimachinery/pkg/apis/meta/v1 k8s.io/apimachinery/pkg/runtime k8s.io/apimachinery/pkg/version
+
+// generatedPkgPath is the package path openapi-gen names our types with,
+// which is the import path of the module.
+const generatedPkgPath = "apiserver-poc"
+
+// pkgPath is the package path reflect gives our types, which is "main" once
+// compiled as a command.
+var pkgPath = reflect.TypeOf(RancherToken{}).PkgPath()
+
+// getDefinitions returns the generated OpenAPI definitions, named after the
+// package path of our types at runtime so that they're found by the
+// definition namer and the builders, which both use reflect.
+func getDefinitions(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+	definitions := GetOpenAPIDefinitions(func(name string) spec.Ref {
+		return ref(runtimeName(name))
+	})
+
+	renamed := make(map[string]common.OpenAPIDefinition, len(definitions))
+	for name, definition := range definitions {
+		for i, dependency := range definition.Dependencies {
+			definition.Dependencies[i] = runtimeName(dependency)
+		}
+		renamed[runtimeName(name)] = definition
+	}
+	return renamed
+}
+
+func runtimeName(name string) string {
+	if rest, ok := strings.CutPrefix(name, generatedPkgPath+"."); ok {
+		return pkgPath + "." + rest
+	}
+	return name
+}
+
+// newOpenAPIConfigs returns the configs of the OpenAPI v2 and v3 specs,
+// which name definitions, and the references to them, with a
+// definitionNamer.
+func newOpenAPIConfigs(scheme *k8sruntime.Scheme) (*common.Config, *common.OpenAPIV3Config) {
+	namer := newDefinitionNamer(scheme)
+
+	config := genericapiserver.DefaultOpenAPIConfig(getDefinitions, namer.namer)
+	config.GetDefinitionName = namer.GetDefinitionName
+
+	v3Config := genericapiserver.DefaultOpenAPIV3Config(getDefinitions, namer.namer)
+	v3Config.GetDefinitionName = namer.GetDefinitionName
+	// The v3 definitions are built once with their references, by the namer
+	// given to DefaultOpenAPIV3Config
+	v3Config.Definitions = getDefinitions(func(name string) spec.Ref {
+		defName, _ := namer.GetDefinitionName(name)
+		return spec.MustCreateRef("#/components/schemas/" + common.EscapeJsonPointer(defName))
+	})
+
+	return config, v3Config
+}
+
+// definitionNamer names the definitions of our types after their group,
+// version and kind in reverse-DNS notation, like the definitions of the
+// kube-apiserver (eg: com.tomlebreux.v1alpha1.RancherToken), so that
+// clients find them with the x-kubernetes-group-version-kind extension.
+// Other definitions are named like openapi.DefinitionNamer names them.
+type definitionNamer struct {
+	names map[string]string
+	namer *openapi.DefinitionNamer
+}
+
+// newDefinitionNamer returns a definitionNamer for the versions of our types
+// registered in the scheme. The types of a version are suffixed with the
+// version (eg: RancherTokenSpecV1alpha1), which is how the definitions that
+// aren't kinds are matched to a version.
+func newDefinitionNamer(scheme *k8sruntime.Scheme) *definitionNamer {
+	versions := map[string]schema.GroupVersion{}
+	for gvk, t := range scheme.AllKnownTypes() {
+		if gvk.Version == k8sruntime.APIVersionInternal || t.PkgPath() != pkgPath {
+			continue
+		}
+		versions[strings.ToUpper(gvk.Version[:1])+gvk.Version[1:]] = gvk.GroupVersion()
 	}
+
+	names := map[string]string{}
+	for name := range getDefinitions(func(string) spec.Ref { return spec.Ref{} }) {
+		typeName, ok := strings.CutPrefix(name, pkgPath+".")
+		if !ok {
+			continue
+		}
+		for suffix, gv := range versions {
+			if kind, ok := strings.CutSuffix(typeName, suffix); ok {
+				names[name] = fmt.Sprintf("%s.%s.%s", reverseDomain(gv.Group), gv.Version, kind)
+			}
+		}
+	}
+
+	return &definitionNamer{
+		names: names,
+		namer: openapi.NewDefinitionNamer(scheme),
+	}
+}
+
+// GetDefinitionName returns the name of the definition, and its
+// x-kubernetes-group-version-kind extension if it's a kind.
+func (n *definitionNamer) GetDefinitionName(name string) (string, spec.Extensions) {
+	friendlyName, extensions := n.namer.GetDefinitionName(name)
+	if renamed, ok := n.names[name]; ok {
+		return renamed, extensions
+	}
+	return friendlyName, extensions
+}
+
+// reverseDomain returns tomlebreux.com as com.tomlebreux.
+func reverseDomain(domain string) string {
+	parts := strings.Split(domain, ".")
+	slices.Reverse(parts)
+	return strings.Join(parts, ".")
+}
+
+// The OpenAPI spec is built the same way as the kube-apiserver does, from
+// go-restful routes. Routes are derived from each resource registered with
+// AddAPIResource and the interfaces its Storage implements, so that the spec
+// matches what the APIServer serves.
+
+const (
+	// routeMetaGVK is the extension with the kind of the objects of a route
+	routeMetaGVK = "x-kubernetes-group-version-kind"
+	// routeMetaAction is the extension with the action of a route
+	routeMetaAction = "x-kubernetes-action"
+)
+
+// updateOpenAPI rebuilds the OpenAPI v2 spec from all the group versions
+// registered, and the OpenAPI v3 spec of the given group version.
+func (as *APIServer) updateOpenAPI(groupVersion schema.GroupVersion) {
+	logger := as.logger.WithField("groupversion", groupVersion)
+
+	webServices := make([]*restful.WebService, 0, len(as.resourceList))
+	var updated *restful.WebService
+	for gv := range as.resourceList {
+		ws, err := as.webService(gv)
+		if err != nil {
+			logger.WithError(err).Error("Could not build OpenAPI routes")
+			return
+		}
+		webServices = append(webServices, ws)
+		if gv == groupVersion {
+			updated = ws
+		}
+	}
+
+	swagger, err := builder.BuildOpenAPISpecFromRoutes(restfuladapter.AdaptWebServices(webServices), as.openAPIConfig)
+	if err != nil {
+		logger.WithError(err).Error("Could not build OpenAPI v2 spec")
+		return
+	}
+	swagger.Definitions = handler.PruneDefaults(swagger.Definitions)
+	if err := as.openAPIV2.UpdateSpec(swagger); err != nil {
+		logger.WithError(err).Error("Could not update OpenAPI v2 spec")
+		return
+	}
+
+	openAPI, err := builder3.BuildOpenAPISpecFromRoutes(restfuladapter.AdaptWebServices([]*restful.WebService{updated}), as.openAPIV3Config)
+	if err != nil {
+		logger.WithError(err).Error("Could not build OpenAPI v3 spec")
+		return
+	}
+	// Served at /openapi/v3/apis/<group>/<version>
+	as.openAPIV3.UpdateGroupVersion(strings.TrimPrefix(updated.RootPath(), "/"), openAPI)
+}
+
+// webService returns the routes of the resources of the group version.
+func (as *APIServer) webService(groupVersion schema.GroupVersion) (*restful.WebService, error) {
+	ws := &restful.WebService{}
+	ws.Path(fmt.Sprintf("/apis/%s", groupVersion))
+
+	for _, resource := range as.resourceList[groupVersion].APIResources {
+		gvr := groupVersion.WithResource(resource.Name)
+		delegate, ok := as.namespacedDelegates[gvr]
+		if !ok {
+			delegate, ok = as.delegates[gvr]
+		}
+		if !ok {
+			continue
+		}
+		if err := as.addRoutes(ws, groupVersion, delegate); err != nil {
+			return nil, errors.Wrapf(err, "resource %s", resource.Name)
+		}
+	}
+	return ws, nil
+}
+
+// addRoutes adds the routes of the verbs the storage of the resource
+// supports, named like the kube-apiserver names them (eg:
+// listNamespacedRancherToken).
+func (as *APIServer) addRoutes(ws *restful.WebService, groupVersion schema.GroupVersion, resource *apiResource) error {
+	kind := resource.Kind
+	gvk := groupVersion.WithKind(kind)
+	// Storages work with internal objects, the spec describes the objects of
+	// the version
+	obj, err := Scheme.New(gvk)
+	if err != nil {
+		return err
+	}
+
+	mediaTypes, streamMediaTypes := negotiation.MediaTypesForSerializer(ResourceCodecs)
+	nameParam := ws.PathParameter("name", "name of the "+kind).DataType("string")
+	namespaceParam := ws.PathParameter("namespace", "object name and auth scope, such as for teams and projects").DataType("string")
+
+	collectionPath := "/" + resource.Name
+	namespaced := ""
+	var params []*restful.Parameter
+	if resource.Namespaced {
+		collectionPath = "/namespaces/{namespace}/" + resource.Name
+		namespaced = "Namespaced"
+		params = append(params, namespaceParam)
+	}
+	itemPath := collectionPath + "/{name}"
+
+	var routes []*restful.RouteBuilder
+	addRoute := func(action string, route *restful.RouteBuilder, options interface{}, params ...*restful.Parameter) error {
+		for _, param := range params {
+			route.Param(param)
+		}
+		if options != nil {
+			if err := endpoints.AddObjectParams(ws, route, options); err != nil {
+				return err
+			}
+		}
+		route.Metadata(routeMetaGVK, metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind})
+		route.Metadata(routeMetaAction, action)
+		routes = append(routes, route)
+		return nil
+	}
+
+	if _, ok := resource.storage.(Lister); ok {
+		list, err := Scheme.New(groupVersion.WithKind(kind + "List"))
+		if err != nil {
+			return err
+		}
+		produces := mediaTypes
+		if _, ok := resource.storage.(Watcher); ok {
+			produces = slices.Concat(mediaTypes, streamMediaTypes)
+		}
+
+		listRoute := func(path, operation string) *restful.RouteBuilder {
+			return ws.GET(path).To(as.serveRoute).
+				Doc("list or watch objects of kind "+kind).
+				Operation(operation).
+				Produces(produces...).
+				Returns(http.StatusOK, "OK", list).
+				Writes(list)
+		}
+		if err := addRoute("list", listRoute(collectionPath, "list"+namespaced+kind), &metav1.ListOptions{}, params...); err != nil {
+			return err
+		}
+		if resource.Namespaced {
+			if err := addRoute("list", listRoute("/"+resource.Name, "list"+kind+"ForAllNamespaces"), &metav1.ListOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, ok := resource.storage.(Creater); ok {
+		route := ws.POST(collectionPath).To(as.serveRoute).
+			Doc("create a "+kind).
+			Operation("create"+namespaced+kind).
+			Consumes(mediaTypes...).
+			Produces(mediaTypes...).
+			Reads(obj).
+			Returns(http.StatusOK, "OK", obj).
+			Returns(http.StatusCreated, "Created", obj).
+			Returns(http.StatusAccepted, "Accepted", obj).
+			Writes(obj)
+		if err := addRoute("post", route, &metav1.CreateOptions{}, params...); err != nil {
+			return err
+		}
+	}
+
+	itemParams := append([]*restful.Parameter{nameParam}, params...)
+
+	if _, ok := resource.storage.(Getter); ok {
+		route := ws.GET(itemPath).To(as.serveRoute).
+			Doc("read the specified "+kind).
+			Operation("read"+namespaced+kind).
+			Produces(mediaTypes...).
+			Returns(http.StatusOK, "OK", obj).
+			Writes(obj)
+		if err := addRoute("get", route, &metav1.GetOptions{}, itemParams...); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := resource.storage.(Updater); ok {
+		route := ws.PUT(itemPath).To(as.serveRoute).
+			Doc("replace the specified "+kind).
+			Operation("replace"+namespaced+kind).
+			Consumes(mediaTypes...).
+			Produces(mediaTypes...).
+			Reads(obj).
+			Returns(http.StatusOK, "OK", obj).
+			Returns(http.StatusCreated, "Created", obj).
+			Writes(obj)
+		if err := addRoute("put", route, &metav1.UpdateOptions{}, itemParams...); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := resource.storage.(Patcher); ok {
+		route := ws.PATCH(itemPath).To(as.serveRoute).
+			Doc("partially update the specified "+kind).
+			Operation("patch"+namespaced+kind).
+			Consumes(patchTypes...).
+			Produces(mediaTypes...).
+			Reads(metav1.Patch{}).
+			Returns(http.StatusOK, "OK", obj).
+			Returns(http.StatusCreated, "Created", obj).
+			Writes(obj)
+		if err := addRoute("patch", route, &metav1.PatchOptions{}, itemParams...); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := resource.storage.(GracefulDeleter); ok {
+		route := ws.DELETE(itemPath).To(as.serveRoute).
+			Doc("delete a "+kind).
+			Operation("delete"+namespaced+kind).
+			Produces(mediaTypes...).
+			Returns(http.StatusOK, "OK", &metav1.Status{}).
+			Returns(http.StatusAccepted, "Accepted", &metav1.Status{}).
+			Writes(&metav1.Status{})
+		if err := addRoute("delete", route, &metav1.DeleteOptions{}, itemParams...); err != nil {
+			return err
+		}
+	}
+
+	for _, route := range routes {
+		ws.Route(route)
+	}
+	return nil
+}
+
+// serveRoute hands requests back to the mux. The routes are only used to
+// build the OpenAPI spec, the mux serves the requests.
+func (as *APIServer) serveRoute(req *restful.Request, resp *restful.Response) {
+	as.mux.ServeHTTP(resp, req.Request)
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"slices"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/authentication/user"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// defaultOwnerBypassGroup is the group whose users see all tokens when tokens
+// are filtered by owner, unless set in ownerBypassGroupEnv.
+const defaultOwnerBypassGroup = user.SystemPrivilegedGroup
+
+// ownerFilter restricts the tokens users can see and change to those whose
+// userID is theirs, unless they are in the bypass group. Tokens of other users
+// are NotFound, so that users can't tell whether they exist.
+type ownerFilter struct {
+	enabled     bool
+	bypassGroup string
+}
+
+// visible returns whether the user of the request can see the tokens of
+// userID. Requests without a user can't see any token.
+func (f ownerFilter) visible(ctx context.Context, userID string) bool {
+	if !f.enabled {
+		return true
+	}
+	u, ok := genericapirequest.UserFrom(ctx)
+	if !ok {
+		return false
+	}
+	return u.GetName() == userID || slices.Contains(u.GetGroups(), f.bypassGroup)
+}
+
+// ownerCheck returns the check of tokenStorage.delete that tokens of other
+// users are NotFound.
+func ownerCheck(ctx context.Context, owners ownerFilter, resource schema.GroupResource) func(*RancherToken) error {
+	return func(token *RancherToken) error {
+		if !owners.visible(ctx, token.Spec.UserID) {
+			return apierrors.NewNotFound(resource, token.Name)
+		}
+		return nil
+	}
+}
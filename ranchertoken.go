@@ -0,0 +1,656 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	wcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+const (
+	// tokenKindLabel is set on the Secrets backing tokens to the kind of
+	// token they back, so that they can be listed and watched
+	tokenKindLabel = "tomlebreux.com/kind"
+
+	// clusterTokenNamespace is the namespace of the Secrets backing
+	// ClusterRancherTokens
+	clusterTokenNamespace = "apiserver-poc-system"
+
+	// storageVersionAnnotation is set on the Secrets backing tokens to the
+	// version of the token their data is stored in
+	storageVersionAnnotation = "tomlebreux.com/storage-version"
+
+	// managedFieldsKey is the key of the data of the Secrets backing tokens
+	// that holds the managedFields of the token. The managedFields of the
+	// Secret itself are those of the kube-apiserver.
+	managedFieldsKey = "managedFields"
+
+	// generationKey is the key of the data of the Secrets backing tokens that
+	// holds the generation of the token, which the kube-apiserver doesn't
+	// let clients change on the Secret itself
+	generationKey = "generation"
+
+	// lookupHashKey is the key of the data of the Secrets backing tokens that
+	// holds the tokenLookupHash of the token
+	lookupHashKey = "lookupHash"
+)
+
+// tokenColumns are the printer columns of RancherTokens and
+// ClusterRancherTokens.
+var tokenColumns = []printerColumn{
+	{
+		TableColumnDefinition: metav1.TableColumnDefinition{Name: "UserID", Type: "string", Description: "The user the token belongs to"},
+		JSONPath:              ".spec.userID",
+	},
+	{
+		TableColumnDefinition: metav1.TableColumnDefinition{Name: "ClusterName", Type: "string", Description: "The cluster the token is scoped to"},
+		JSONPath:              ".spec.clusterName",
+	},
+	{
+		TableColumnDefinition: metav1.TableColumnDefinition{Name: "TTL", Type: "string", Description: "How long the token is valid for"},
+		JSONPath:              ".spec.ttl",
+	},
+	{
+		TableColumnDefinition: metav1.TableColumnDefinition{Name: "Enabled", Type: "boolean", Description: "Whether the token can be used"},
+		JSONPath:              ".spec.enabled",
+	},
+	{
+		TableColumnDefinition: metav1.TableColumnDefinition{Name: "Expired", Type: "boolean", Description: "Whether the TTL of the token has passed"},
+		JSONPath:              ".status.expired",
+	},
+}
+
+var (
+	_ Getter          = (*rancherTokenStore)(nil)
+	_ Lister          = (*rancherTokenStore)(nil)
+	_ Creater         = (*rancherTokenStore)(nil)
+	_ Patcher         = (*rancherTokenStore)(nil)
+	_ CreateOnUpdater = (*rancherTokenStore)(nil)
+	_ GracefulDeleter = (*rancherTokenStore)(nil)
+	_ Watcher         = (*rancherTokenStore)(nil)
+	_ TableConvertor  = (*rancherTokenStore)(nil)
+
+	_ Getter          = (*clusterRancherTokenStore)(nil)
+	_ Lister          = (*clusterRancherTokenStore)(nil)
+	_ Creater         = (*clusterRancherTokenStore)(nil)
+	_ Patcher         = (*clusterRancherTokenStore)(nil)
+	_ CreateOnUpdater = (*clusterRancherTokenStore)(nil)
+	_ GracefulDeleter = (*clusterRancherTokenStore)(nil)
+	_ Watcher         = (*clusterRancherTokenStore)(nil)
+	_ TableConvertor  = (*clusterRancherTokenStore)(nil)
+)
+
+// tokenConfig is the configuration of the stores of tokens.
+type tokenConfig struct {
+	// storageVersion is the version tokens are stored in
+	storageVersion schema.GroupVersion
+	// hashAlgorithm is the algorithm new tokens are hashed with
+	hashAlgorithm string
+	// owners restricts the tokens users can see to their own
+	owners ownerFilter
+	// authorizer answers whether users can write the fields of tokens, eg:
+	// the userID of another user. Nothing is checked if it's nil.
+	authorizer authorizer.Authorizer
+}
+
+// rancherTokenStore is the Storage for RancherTokens. Each RancherToken is
+// backed by a Secret of the same name in the same namespace, in which it is
+// stored in the storageVersion.
+type rancherTokenStore struct {
+	*tableConvertor
+
+	tokens       *tokenStorage
+	owners       ownerFilter
+	access       *accessReviewer
+	secretClient wcorev1.SecretController
+	watchCache   *secretWatchCache
+}
+
+func newRancherTokenStore(secretClient wcorev1.SecretController, watchCache *secretWatchCache, config tokenConfig) *rancherTokenStore {
+	return &rancherTokenStore{
+		tableConvertor: newTableConvertor(tokenColumns...),
+		tokens:         newTokenStorage(secretClient, "RancherToken", "ranchertokens", config),
+		owners:         config.owners,
+		access:         &accessReviewer{authorizer: config.authorizer},
+		secretClient:   secretClient,
+		watchCache:     watchCache,
+	}
+}
+
+func (s *rancherTokenStore) New() k8sruntime.Object {
+	return &RancherToken{}
+}
+
+func (s *rancherTokenStore) Get(ctx context.Context, name string, _ *metav1.GetOptions) (k8sruntime.Object, error) {
+	token, err := s.tokens.get(genericapirequest.NamespaceValue(ctx), name)
+	if err != nil {
+		return nil, err
+	}
+	if !s.owners.visible(ctx, token.Spec.UserID) {
+		return nil, apierrors.NewNotFound(s.tokens.resource, name)
+	}
+	return token, nil
+}
+
+func (s *rancherTokenStore) NewList() k8sruntime.Object {
+	return &RancherTokenList{}
+}
+
+func (s *rancherTokenStore) List(ctx context.Context, options *metav1.ListOptions) (k8sruntime.Object, error) {
+	listOptions, err := tokenListOptions(options, "RancherToken")
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := s.secretClient.List(genericapirequest.NamespaceValue(ctx), listOptions)
+	if err != nil {
+		return nil, secretError(err, Resource("ranchertokens"), "RancherToken", "")
+	}
+
+	list := &RancherTokenList{
+		ListMeta: secrets.ListMeta,
+		Items:    make([]RancherToken, 0, len(secrets.Items)),
+	}
+	if s.owners.enabled {
+		// The count is of the remaining Secrets, not of those the user can see
+		list.RemainingItemCount = nil
+	}
+	for i := range secrets.Items {
+		token, err := tokenFromSecret(&secrets.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		if !s.owners.visible(ctx, token.Spec.UserID) {
+			continue
+		}
+		list.Items = append(list.Items, *token)
+	}
+	return list, nil
+}
+
+// Watch watches the RancherTokens of the namespace of the request, or of all
+// namespaces if there is none.
+func (s *rancherTokenStore) Watch(ctx context.Context, options *metav1.ListOptions) (watch.Interface, error) {
+	namespace := genericapirequest.NamespaceValue(ctx)
+	filter := func(secret *corev1.Secret) bool {
+		return (namespace == "" || secret.Namespace == namespace) && secret.Labels[tokenKindLabel] == "RancherToken" &&
+			s.owners.visible(ctx, string(secret.Data["userID"]))
+	}
+	convert := func(secret *corev1.Secret) (k8sruntime.Object, error) {
+		return tokenFromSecret(secret)
+	}
+	return s.watchCache.Watch(filter, convert, options)
+}
+
+func (s *rancherTokenStore) Create(ctx context.Context, obj k8sruntime.Object, createValidation ValidateObjectFunc, options *metav1.CreateOptions) (k8sruntime.Object, error) {
+	token := obj.(*RancherToken)
+	if err := s.access.checkToken(ctx, token, nil); err != nil {
+		return nil, err
+	}
+	return s.tokens.create(token, s.validateCreate(ctx, createValidation), options.DryRun)
+}
+
+func (s *rancherTokenStore) AllowCreateOnUpdate() bool {
+	return true
+}
+
+// Update replaces the token, or creates it if it doesn't exist.
+func (s *rancherTokenStore) Update(ctx context.Context, name string, objInfo UpdatedObjectInfo, createValidation ValidateObjectFunc, updateValidation ValidateObjectUpdateFunc, options *metav1.UpdateOptions) (k8sruntime.Object, bool, error) {
+	updateFn := func(ctx context.Context, old *RancherToken) (*RancherToken, error) {
+		if old.UID != "" && !s.owners.visible(ctx, old.Spec.UserID) {
+			return nil, apierrors.NewNotFound(s.tokens.resource, name)
+		}
+		obj, err := objInfo.UpdatedObject(ctx, old)
+		if err != nil {
+			return nil, err
+		}
+		token := obj.(*RancherToken)
+		if err := s.access.checkToken(ctx, token, existingToken(old)); err != nil {
+			return nil, err
+		}
+		return token, nil
+	}
+	validateUpdate := func(token, old *RancherToken) error {
+		return updateValidation(ctx, token, old)
+	}
+	token, created, err := s.tokens.update(ctx, genericapirequest.NamespaceValue(ctx), name, updateFn, s.validateCreate(ctx, createValidation), validateUpdate, options.DryRun)
+	if err != nil {
+		return nil, false, err
+	}
+	return token, created, nil
+}
+
+// validateCreate returns the check of tokenStorage.create that createValidation
+// accepts the token.
+func (s *rancherTokenStore) validateCreate(ctx context.Context, createValidation ValidateObjectFunc) func(*RancherToken) error {
+	return func(token *RancherToken) error {
+		return createValidation(ctx, token)
+	}
+}
+
+func (s *rancherTokenStore) Delete(ctx context.Context, name string, deleteValidation ValidateObjectFunc, options *metav1.DeleteOptions) (k8sruntime.Object, bool, error) {
+	ownerFn := ownerCheck(ctx, s.owners, s.tokens.resource)
+	preconditionsFn := preconditionsCheck(options.Preconditions, s.tokens.resource)
+	checkFn := func(token *RancherToken) error {
+		if err := ownerFn(token); err != nil {
+			return err
+		}
+		if err := preconditionsFn(token); err != nil {
+			return err
+		}
+		return deleteValidation(ctx, token)
+	}
+	if err := s.tokens.delete(genericapirequest.NamespaceValue(ctx), name, checkFn, options.DryRun); err != nil {
+		return nil, false, err
+	}
+	return nil, true, nil
+}
+
+// clusterRancherTokenStore is the Storage for ClusterRancherTokens. Each
+// ClusterRancherToken is backed by a Secret of the same name in the
+// clusterTokenNamespace.
+type clusterRancherTokenStore struct {
+	*tableConvertor
+
+	tokens       *tokenStorage
+	owners       ownerFilter
+	access       *accessReviewer
+	secretClient wcorev1.SecretController
+	watchCache   *secretWatchCache
+}
+
+func newClusterRancherTokenStore(secretClient wcorev1.SecretController, watchCache *secretWatchCache, config tokenConfig) *clusterRancherTokenStore {
+	return &clusterRancherTokenStore{
+		tableConvertor: newTableConvertor(tokenColumns...),
+		tokens:         newTokenStorage(secretClient, "ClusterRancherToken", "clusterranchertokens", config),
+		owners:         config.owners,
+		access:         &accessReviewer{authorizer: config.authorizer},
+		secretClient:   secretClient,
+		watchCache:     watchCache,
+	}
+}
+
+func (s *clusterRancherTokenStore) New() k8sruntime.Object {
+	return &ClusterRancherToken{}
+}
+
+func (s *clusterRancherTokenStore) Get(ctx context.Context, name string, _ *metav1.GetOptions) (k8sruntime.Object, error) {
+	token, err := s.tokens.get(clusterTokenNamespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if !s.owners.visible(ctx, token.Spec.UserID) {
+		return nil, apierrors.NewNotFound(s.tokens.resource, name)
+	}
+	return clusterTokenFromToken(token), nil
+}
+
+func (s *clusterRancherTokenStore) NewList() k8sruntime.Object {
+	return &ClusterRancherTokenList{}
+}
+
+func (s *clusterRancherTokenStore) List(ctx context.Context, options *metav1.ListOptions) (k8sruntime.Object, error) {
+	listOptions, err := tokenListOptions(options, "ClusterRancherToken")
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := s.secretClient.List(clusterTokenNamespace, listOptions)
+	if err != nil {
+		return nil, secretError(err, Resource("clusterranchertokens"), "ClusterRancherToken", "")
+	}
+
+	list := &ClusterRancherTokenList{
+		ListMeta: secrets.ListMeta,
+		Items:    make([]ClusterRancherToken, 0, len(secrets.Items)),
+	}
+	if s.owners.enabled {
+		// The count is of the remaining Secrets, not of those the user can see
+		list.RemainingItemCount = nil
+	}
+	for i := range secrets.Items {
+		token, err := clusterTokenFromSecret(&secrets.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		if !s.owners.visible(ctx, token.Spec.UserID) {
+			continue
+		}
+		list.Items = append(list.Items, *token)
+	}
+	return list, nil
+}
+
+func (s *clusterRancherTokenStore) Watch(ctx context.Context, options *metav1.ListOptions) (watch.Interface, error) {
+	filter := func(secret *corev1.Secret) bool {
+		return secret.Namespace == clusterTokenNamespace && secret.Labels[tokenKindLabel] == "ClusterRancherToken" &&
+			s.owners.visible(ctx, string(secret.Data["userID"]))
+	}
+	convert := func(secret *corev1.Secret) (k8sruntime.Object, error) {
+		return clusterTokenFromSecret(secret)
+	}
+	return s.watchCache.Watch(filter, convert, options)
+}
+
+func (s *clusterRancherTokenStore) Create(ctx context.Context, obj k8sruntime.Object, createValidation ValidateObjectFunc, options *metav1.CreateOptions) (k8sruntime.Object, error) {
+	token := tokenFromClusterToken(obj.(*ClusterRancherToken))
+	if err := s.access.checkToken(ctx, token, nil); err != nil {
+		return nil, err
+	}
+	token, err := s.tokens.create(token, s.validateCreate(ctx, createValidation), options.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	return clusterTokenFromToken(token), nil
+}
+
+func (s *clusterRancherTokenStore) AllowCreateOnUpdate() bool {
+	return true
+}
+
+// Update replaces the token, or creates it if it doesn't exist.
+func (s *clusterRancherTokenStore) Update(ctx context.Context, name string, objInfo UpdatedObjectInfo, createValidation ValidateObjectFunc, updateValidation ValidateObjectUpdateFunc, options *metav1.UpdateOptions) (k8sruntime.Object, bool, error) {
+	updateFn := func(ctx context.Context, old *RancherToken) (*RancherToken, error) {
+		if old.UID != "" && !s.owners.visible(ctx, old.Spec.UserID) {
+			return nil, apierrors.NewNotFound(s.tokens.resource, name)
+		}
+		obj, err := objInfo.UpdatedObject(ctx, clusterTokenFromToken(old))
+		if err != nil {
+			return nil, err
+		}
+		token := tokenFromClusterToken(obj.(*ClusterRancherToken))
+		if err := s.access.checkToken(ctx, token, existingToken(old)); err != nil {
+			return nil, err
+		}
+		return token, nil
+	}
+	validateUpdate := func(token, old *RancherToken) error {
+		return updateValidation(ctx, clusterTokenFromToken(token), clusterTokenFromToken(old))
+	}
+	token, created, err := s.tokens.update(ctx, clusterTokenNamespace, name, updateFn, s.validateCreate(ctx, createValidation), validateUpdate, options.DryRun)
+	if err != nil {
+		return nil, false, err
+	}
+	return clusterTokenFromToken(token), created, nil
+}
+
+// validateCreate returns the check of tokenStorage.create that createValidation
+// accepts the token.
+func (s *clusterRancherTokenStore) validateCreate(ctx context.Context, createValidation ValidateObjectFunc) func(*RancherToken) error {
+	return func(token *RancherToken) error {
+		return createValidation(ctx, clusterTokenFromToken(token))
+	}
+}
+
+func (s *clusterRancherTokenStore) Delete(ctx context.Context, name string, deleteValidation ValidateObjectFunc, options *metav1.DeleteOptions) (k8sruntime.Object, bool, error) {
+	ownerFn := ownerCheck(ctx, s.owners, s.tokens.resource)
+	preconditionsFn := preconditionsCheck(options.Preconditions, s.tokens.resource)
+	checkFn := func(token *RancherToken) error {
+		if err := ownerFn(token); err != nil {
+			return err
+		}
+		if err := preconditionsFn(token); err != nil {
+			return err
+		}
+		return deleteValidation(ctx, clusterTokenFromToken(token))
+	}
+	if err := s.tokens.delete(clusterTokenNamespace, name, checkFn, options.DryRun); err != nil {
+		return nil, false, err
+	}
+	return nil, true, nil
+}
+
+// existingToken returns the token an update is made against, or nil if the
+// update creates it.
+func existingToken(old *RancherToken) *RancherToken {
+	if old.UID == "" {
+		return nil
+	}
+	return old
+}
+
+// tokenListOptions returns the options to list the Secrets backing tokens of
+// the given kind, restricted by the label and field selectors of the client.
+func tokenListOptions(options *metav1.ListOptions, kind string) (metav1.ListOptions, error) {
+	if _, err := parseFieldSelector(options.FieldSelector); err != nil {
+		return metav1.ListOptions{}, err
+	}
+	secretOptions := *options
+	selectors := []string{tokenKindLabel + "=" + kind}
+	if options.LabelSelector != "" {
+		selectors = append(selectors, options.LabelSelector)
+	}
+	secretOptions.LabelSelector = strings.Join(selectors, ",")
+	return secretOptions, nil
+}
+
+// secretError translates an error of the Secret client to an error about the
+// token resource backed by the Secret, so that clients don't see Secrets in
+// the Status. Errors due to the permissions of the apiserver itself are
+// internal errors.
+func secretError(err error, resource schema.GroupResource, kind string, name string) error {
+	switch {
+	case apierrors.IsNotFound(err):
+		return apierrors.NewNotFound(resource, name)
+	case apierrors.IsAlreadyExists(err):
+		return apierrors.NewAlreadyExists(resource, name)
+	case apierrors.IsConflict(err):
+		return apierrors.NewConflict(resource, name, errors.New(optimisticLockErrorMsg))
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err):
+		return apierrors.NewInternalError(err)
+	}
+
+	apiStatus, ok := err.(apierrors.APIStatus)
+	if !ok {
+		return apierrors.NewInternalError(err)
+	}
+	status := apiStatus.Status()
+	if status.Reason == metav1.StatusReasonInvalid && status.Details != nil {
+		// Keep the causes, but report them against the token
+		var errs field.ErrorList
+		for _, cause := range status.Details.Causes {
+			errorType := field.ErrorType(cause.Type)
+			errs = append(errs, &field.Error{
+				Type:     errorType,
+				Field:    cause.Field,
+				BadValue: field.OmitValueType{},
+				// The message of the cause starts with its type, which is
+				// added back by the error
+				Detail: strings.TrimPrefix(cause.Message, errorType.String()+": "),
+			})
+		}
+		return apierrors.NewInvalid(Kind(kind), name, errs)
+	}
+	return &apierrors.StatusError{ErrStatus: status}
+}
+
+// getSecretAndToken returns the Secret backing the token of the given kind.
+// Secrets that don't back a token of the kind are NotFound.
+func getSecretAndToken(secretClient wcorev1.SecretController, kind string, ns string, resourceName string) (*corev1.Secret, *RancherToken, error) {
+	secret, err := secretClient.Get(ns, resourceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if secret.Labels[tokenKindLabel] != kind {
+		return nil, nil, apierrors.NewNotFound(corev1.Resource("secrets"), resourceName)
+	}
+
+	token, err := tokenFromSecret(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	return secret, token, nil
+}
+
+// tokenFromSecret reads the token from the data of the Secret, in the version
+// the Secret was stored in, and converts it to the internal version. Whether
+// the token has expired is computed on each read.
+func tokenFromSecret(secret *corev1.Secret) (*RancherToken, error) {
+	objectMeta := *secret.ObjectMeta.DeepCopy()
+	delete(objectMeta.Labels, tokenKindLabel)
+	delete(objectMeta.Annotations, storageVersionAnnotation)
+	// The finalizers and ownerReferences of the Secret aren't the token's
+	objectMeta.Finalizers = nil
+	objectMeta.OwnerReferences = nil
+	objectMeta.ManagedFields = nil
+	if managedFields := secret.Data[managedFieldsKey]; len(managedFields) > 0 {
+		if err := json.Unmarshal(managedFields, &objectMeta.ManagedFields); err != nil {
+			return nil, errors.Wrapf(err, "invalid managedFields in Secret %s/%s", secret.Namespace, secret.Name)
+		}
+	}
+	if generation := string(secret.Data[generationKey]); generation != "" {
+		g, err := strconv.ParseInt(generation, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid generation in Secret %s/%s", secret.Namespace, secret.Name)
+		}
+		objectMeta.Generation = g
+	}
+
+	var stored k8sruntime.Object
+	switch version := secret.Annotations[storageVersionAnnotation]; version {
+	// Secrets stored before the storage version was recorded are v1alpha1
+	case "", SchemeGroupVersionV1alpha1.Version:
+		stored = &RancherTokenV1alpha1{
+			ObjectMeta: objectMeta,
+			Spec: RancherTokenSpecV1alpha1{
+				UserID:      string(secret.Data["userID"]),
+				ClusterName: string(secret.Data["clusterName"]),
+				TTL:         string(secret.Data["ttl"]),
+				Enabled:     string(secret.Data["enabled"]),
+			},
+			Status: RancherTokenStatusV1alpha1{
+				HashedToken: string(secret.Data["hashedToken"]),
+			},
+		}
+	case SchemeGroupVersionV1beta1.Version:
+		token := &RancherTokenV1beta1{
+			ObjectMeta: objectMeta,
+			Spec: RancherTokenSpecV1beta1{
+				UserID:      string(secret.Data["userID"]),
+				ClusterName: string(secret.Data["clusterName"]),
+			},
+			Status: RancherTokenStatusV1beta1{
+				HashedToken: string(secret.Data["hashedToken"]),
+			},
+		}
+		if ttl := string(secret.Data["ttl"]); ttl != "" {
+			d, err := time.ParseDuration(ttl)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid ttl in Secret %s/%s", secret.Namespace, secret.Name)
+			}
+			token.Spec.TTL = metav1.Duration{Duration: d}
+		}
+		if enabled := string(secret.Data["enabled"]); enabled != "" {
+			b, err := strconv.ParseBool(enabled)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid enabled in Secret %s/%s", secret.Namespace, secret.Name)
+			}
+			token.Spec.Enabled = b
+		}
+		stored = token
+	default:
+		return nil, errors.Errorf("unknown storage version %q of Secret %s/%s", version, secret.Namespace, secret.Name)
+	}
+
+	token := &RancherToken{}
+	if err := Scheme.Convert(stored, token, nil); err != nil {
+		return nil, errors.Wrapf(err, "could not convert Secret %s/%s", secret.Namespace, secret.Name)
+	}
+	setTokenExpiry(token, time.Now())
+	return token, nil
+}
+
+func clusterTokenFromSecret(secret *corev1.Secret) (*ClusterRancherToken, error) {
+	token, err := tokenFromSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return clusterTokenFromToken(token), nil
+}
+
+// clusterTokenFromToken returns the ClusterRancherToken of a token stored in
+// the clusterTokenNamespace.
+func clusterTokenFromToken(token *RancherToken) *ClusterRancherToken {
+	clusterToken := &ClusterRancherToken{
+		ObjectMeta: *token.ObjectMeta.DeepCopy(),
+		Spec:       token.Spec,
+		Status:     token.Status,
+	}
+	clusterToken.Namespace = ""
+	return clusterToken
+}
+
+// tokenFromClusterToken returns the ClusterRancherToken as a token stored in
+// the clusterTokenNamespace.
+func tokenFromClusterToken(clusterToken *ClusterRancherToken) *RancherToken {
+	token := &RancherToken{
+		ObjectMeta: *clusterToken.ObjectMeta.DeepCopy(),
+		Spec:       clusterToken.Spec,
+		Status:     clusterToken.Status,
+	}
+	token.Namespace = clusterTokenNamespace
+	return token
+}
+
+// secretFromToken returns the Secret backing the token of the given kind,
+// with the token stored in the given version.
+func secretFromToken(token *RancherToken, kind string, storageVersion schema.GroupVersion) (*corev1.Secret, error) {
+	stored, err := Scheme.ConvertToVersion(token, storageVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: *token.ObjectMeta.DeepCopy(),
+		StringData: make(map[string]string),
+		Data:       make(map[string][]byte),
+	}
+	if secret.Labels == nil {
+		secret.Labels = map[string]string{}
+	}
+	secret.Labels[tokenKindLabel] = kind
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[storageVersionAnnotation] = storageVersion.Version
+
+	secret.Generation = 0
+	secret.StringData[generationKey] = strconv.FormatInt(token.Generation, 10)
+	secret.ManagedFields = nil
+	if len(token.ManagedFields) > 0 {
+		managedFields, err := json.Marshal(token.ManagedFields)
+		if err != nil {
+			return nil, err
+		}
+		secret.StringData[managedFieldsKey] = string(managedFields)
+	}
+
+	switch stored := stored.(type) {
+	case *RancherTokenV1alpha1:
+		secret.StringData["userID"] = stored.Spec.UserID
+		secret.StringData["clusterName"] = stored.Spec.ClusterName
+		secret.StringData["ttl"] = stored.Spec.TTL
+		secret.StringData["hashedToken"] = stored.Status.HashedToken
+		secret.StringData["enabled"] = stored.Spec.Enabled
+	case *RancherTokenV1beta1:
+		secret.StringData["userID"] = stored.Spec.UserID
+		secret.StringData["clusterName"] = stored.Spec.ClusterName
+		secret.StringData["ttl"] = stored.Spec.TTL.Duration.String()
+		secret.StringData["hashedToken"] = stored.Status.HashedToken
+		secret.StringData["enabled"] = strconv.FormatBool(stored.Spec.Enabled)
+	default:
+		return nil, errors.Errorf("unsupported storage version %s", storageVersion)
+	}
+	return secret, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestSecretErrorInvalid(t *testing.T) {
+	err := apierrors.NewInvalid(corev1.SchemeGroupVersion.WithKind("Secret").GroupKind(), "token", field.ErrorList{
+		field.Invalid(field.NewPath("metadata", "labels"), "-", "must start with an alphanumeric character"),
+		field.Required(field.NewPath("metadata", "name"), "name is required"),
+	})
+	want := apierrors.NewInvalid(Kind("RancherToken"), "token", field.ErrorList{
+		field.Invalid(field.NewPath("metadata", "labels"), "-", "must start with an alphanumeric character"),
+		field.Required(field.NewPath("metadata", "name"), "name is required"),
+	})
+
+	got, ok := secretError(err, Resource("ranchertokens"), "RancherToken", "token").(*apierrors.StatusError)
+	if !ok {
+		t.Fatalf("got %T, want a StatusError", got)
+	}
+	if got.ErrStatus.Message != want.ErrStatus.Message {
+		t.Errorf("got message %q, want %q", got.ErrStatus.Message, want.ErrStatus.Message)
+	}
+	if got.ErrStatus.Details.Kind != "RancherToken" || got.ErrStatus.Details.Group != GroupName {
+		t.Errorf("got details of %s.%s, want RancherToken.%s", got.ErrStatus.Details.Kind, got.ErrStatus.Details.Group, GroupName)
+	}
+	if len(got.ErrStatus.Details.Causes) != len(want.ErrStatus.Details.Causes) {
+		t.Fatalf("got causes %+v, want %+v", got.ErrStatus.Details.Causes, want.ErrStatus.Details.Causes)
+	}
+	for i, cause := range got.ErrStatus.Details.Causes {
+		if cause != want.ErrStatus.Details.Causes[i] {
+			t.Errorf("got cause %+v, want %+v", cause, want.ErrStatus.Details.Causes[i])
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/request/headerrequest"
+	x509request "k8s.io/apiserver/pkg/authentication/request/x509"
+	"k8s.io/client-go/util/cert"
+)
+
+const (
+	// authenticationConfigMapNamespace and authenticationConfigMapName are the
+	// ConfigMap the kube-apiserver publishes the configuration of its front
+	// proxy in, for extension apiservers
+	authenticationConfigMapNamespace = "kube-system"
+	authenticationConfigMapName      = "extension-apiserver-authentication"
+
+	// The keys of the authenticationConfigMap. Apart from the CA, they are
+	// JSON lists.
+	requestHeaderClientCAKey            = "requestheader-client-ca-file"
+	requestHeaderAllowedNamesKey        = "requestheader-allowed-names"
+	requestHeaderUsernameHeadersKey     = "requestheader-username-headers"
+	requestHeaderGroupHeadersKey        = "requestheader-group-headers"
+	requestHeaderExtraHeaderPrefixesKey = "requestheader-extra-headers-prefix"
+)
+
+// requestHeaderConfig is the configuration of the front proxy (ie: the
+// aggregator) that requests are authenticated with.
+type requestHeaderConfig struct {
+	// verifyOptions verify the client certificate of the front proxy
+	verifyOptions x509.VerifyOptions
+	// allowedNames are the common names the client certificate can have. Any
+	// name is allowed when empty.
+	allowedNames        []string
+	usernameHeaders     []string
+	groupHeaders        []string
+	extraHeaderPrefixes []string
+}
+
+// requestHeaderAuthenticator authenticates the requests proxied by the
+// aggregator as the user set in the request headers, once the client
+// certificate of the aggregator is verified. The configuration is kept up to
+// date with the authenticationConfigMap, and no request is authenticated until
+// it's loaded.
+type requestHeaderAuthenticator struct {
+	lock   sync.RWMutex
+	config *requestHeaderConfig
+
+	authenticator authenticator.Request
+}
+
+func newRequestHeaderAuthenticator() *requestHeaderAuthenticator {
+	a := &requestHeaderAuthenticator{}
+	a.authenticator = headerrequest.NewDynamicVerifyOptionsSecure(
+		a.verifyOptions,
+		a.stringSlice(func(c *requestHeaderConfig) []string { return c.allowedNames }),
+		a.stringSlice(func(c *requestHeaderConfig) []string { return c.usernameHeaders }),
+		a.stringSlice(func(c *requestHeaderConfig) []string { return c.groupHeaders }),
+		a.stringSlice(func(c *requestHeaderConfig) []string { return c.extraHeaderPrefixes }),
+	)
+	return a
+}
+
+// AuthenticateRequest verifies the client certificate of the request on each
+// request, then returns the user of the request headers, with its extra
+// values. The headers are removed from the request.
+func (a *requestHeaderAuthenticator) AuthenticateRequest(r *http.Request) (*authenticator.Response, bool, error) {
+	return a.authenticator.AuthenticateRequest(r)
+}
+
+func (a *requestHeaderAuthenticator) verifyOptions() (x509.VerifyOptions, bool) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	if a.config == nil {
+		return x509.VerifyOptions{}, false
+	}
+	return a.config.verifyOptions, true
+}
+
+func (a *requestHeaderAuthenticator) stringSlice(get func(*requestHeaderConfig) []string) headerrequest.StringSliceProvider {
+	return headerrequest.StringSliceProviderFunc(func() []string {
+		a.lock.RLock()
+		defer a.lock.RUnlock()
+		if a.config == nil {
+			return nil
+		}
+		return get(a.config)
+	})
+}
+
+// sync loads the configuration from the authenticationConfigMap. Requests
+// aren't authenticated anymore if it's deleted.
+func (a *requestHeaderAuthenticator) sync(key string, configMap *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	// The ConfigMap is nil for deletes, of any ConfigMap
+	if key != authenticationConfigMapNamespace+"/"+authenticationConfigMapName {
+		return configMap, nil
+	}
+
+	var config *requestHeaderConfig
+	if configMap != nil && configMap.DeletionTimestamp == nil {
+		var err error
+		// The previous configuration is kept until the ConfigMap is fixed
+		config, err = requestHeaderConfigFromConfigMap(configMap)
+		if err != nil {
+			return configMap, errors.Wrapf(err, "could not load ConfigMap %s/%s", configMap.Namespace, configMap.Name)
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.config = config
+	return configMap, nil
+}
+
+func requestHeaderConfigFromConfigMap(configMap *corev1.ConfigMap) (*requestHeaderConfig, error) {
+	clientCA := configMap.Data[requestHeaderClientCAKey]
+	if clientCA == "" {
+		return nil, errors.Errorf("missing %s", requestHeaderClientCAKey)
+	}
+	roots, err := cert.NewPoolFromBytes([]byte(clientCA))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", requestHeaderClientCAKey)
+	}
+	config := &requestHeaderConfig{
+		verifyOptions: x509request.DefaultVerifyOptions(),
+	}
+	config.verifyOptions.Roots = roots
+
+	lists := map[string]*[]string{
+		requestHeaderAllowedNamesKey:        &config.allowedNames,
+		requestHeaderUsernameHeadersKey:     &config.usernameHeaders,
+		requestHeaderGroupHeadersKey:        &config.groupHeaders,
+		requestHeaderExtraHeaderPrefixesKey: &config.extraHeaderPrefixes,
+	}
+	for key, list := range lists {
+		value := configMap.Data[key]
+		if value == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(value), list); err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", key)
+		}
+	}
+	if len(config.usernameHeaders) == 0 {
+		return nil, errors.Errorf("missing %s", requestHeaderUsernameHeadersKey)
+	}
+	return config, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// The interfaces in this file are modeled on k8s.io/apiserver/pkg/registry/rest.
+// A resource registered with AddAPIResource provides a Storage, and the verbs
+// it supports are derived from the other interfaces it implements.
+
+// Storage is the minimal interface a resource storage must implement.
+type Storage interface {
+	// New returns an empty object that request bodies can be decoded into.
+	New() k8sruntime.Object
+}
+
+// Getter is an object that can retrieve a named object.
+type Getter interface {
+	Get(ctx context.Context, name string, options *metav1.GetOptions) (k8sruntime.Object, error)
+}
+
+// Lister is an object that can retrieve objects that match the given options.
+type Lister interface {
+	// NewList returns an empty object that can be used with the List call.
+	NewList() k8sruntime.Object
+	List(ctx context.Context, options *metav1.ListOptions) (k8sruntime.Object, error)
+}
+
+// Creater is an object that can create an instance of a resource. The object
+// is only created if createValidation accepts it.
+type Creater interface {
+	New() k8sruntime.Object
+	Create(ctx context.Context, obj k8sruntime.Object, createValidation ValidateObjectFunc, options *metav1.CreateOptions) (k8sruntime.Object, error)
+}
+
+// UpdatedObjectInfo provides the updated object given the object currently
+// stored, which lets the same Update call serve both PUT and PATCH requests.
+type UpdatedObjectInfo interface {
+	UpdatedObject(ctx context.Context, oldObj k8sruntime.Object) (newObj k8sruntime.Object, err error)
+}
+
+// Updater is an object that can update an instance of a resource. It returns
+// the updated object and whether it was created. The object is only written
+// if createValidation, or updateValidation, accepts it.
+type Updater interface {
+	New() k8sruntime.Object
+	Update(ctx context.Context, name string, objInfo UpdatedObjectInfo, createValidation ValidateObjectFunc, updateValidation ValidateObjectUpdateFunc, options *metav1.UpdateOptions) (k8sruntime.Object, bool, error)
+}
+
+// CreateOnUpdater is an Updater that can create objects that don't exist,
+// like the strategies of the kube-apiserver that allow create on update.
+type CreateOnUpdater interface {
+	AllowCreateOnUpdate() bool
+}
+
+// Patcher is a storage that supports both get and update, which is what the
+// APIServer needs to apply a patch.
+type Patcher interface {
+	Getter
+	Updater
+}
+
+// GracefulDeleter is an object that can delete a named object. It returns the
+// deleted object and whether it was deleted immediately. The object is only
+// deleted if deleteValidation accepts it.
+type GracefulDeleter interface {
+	Delete(ctx context.Context, name string, deleteValidation ValidateObjectFunc, options *metav1.DeleteOptions) (k8sruntime.Object, bool, error)
+}
+
+// ValidateObjectFunc is a function to check whether the object can be
+// written, eg: by admission webhooks.
+type ValidateObjectFunc func(ctx context.Context, obj k8sruntime.Object) error
+
+// ValidateObjectUpdateFunc is a function to check whether the object can be
+// updated, eg: by admission webhooks.
+type ValidateObjectUpdateFunc func(ctx context.Context, obj, old k8sruntime.Object) error
+
+// Watcher is an object that can watch for changes to the objects that match
+// the given options.
+type Watcher interface {
+	Watch(ctx context.Context, options *metav1.ListOptions) (watch.Interface, error)
+}
+
+// TableConvertor is an object that can convert objects to a metav1.Table for
+// display by kubectl.
+type TableConvertor interface {
+	ConvertToTable(ctx context.Context, object k8sruntime.Object, tableOptions k8sruntime.Object) (*metav1.Table, error)
+}
+
+// TransformFunc is a function to transform the object of an update, given
+// the object currently stored.
+type TransformFunc func(ctx context.Context, newObj k8sruntime.Object, oldObj k8sruntime.Object) (transformedNewObj k8sruntime.Object, err error)
+
+// defaultUpdatedObjectInfo returns the object it was created with, regardless
+// of the object currently stored, after applying its transformers.
+type defaultUpdatedObjectInfo struct {
+	obj          k8sruntime.Object
+	transformers []TransformFunc
+}
+
+// DefaultUpdatedObjectInfo returns an UpdatedObjectInfo that always returns
+// obj, transformed by the given transformers.
+func DefaultUpdatedObjectInfo(obj k8sruntime.Object, transformers ...TransformFunc) UpdatedObjectInfo {
+	return &defaultUpdatedObjectInfo{obj: obj, transformers: transformers}
+}
+
+func (i *defaultUpdatedObjectInfo) UpdatedObject(ctx context.Context, oldObj k8sruntime.Object) (k8sruntime.Object, error) {
+	newObj := i.obj.DeepCopyObject()
+	for _, transformer := range i.transformers {
+		var err error
+		newObj, err = transformer(ctx, newObj, oldObj)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newObj, nil
+}
+
+// wrappedUpdatedObjectInfo returns the object of the UpdatedObjectInfo it
+// wraps, after applying its transformers.
+type wrappedUpdatedObjectInfo struct {
+	objInfo      UpdatedObjectInfo
+	transformers []TransformFunc
+}
+
+// WrapUpdatedObjectInfo returns an UpdatedObjectInfo that transforms the
+// object of objInfo with the given transformers.
+func WrapUpdatedObjectInfo(objInfo UpdatedObjectInfo, transformers ...TransformFunc) UpdatedObjectInfo {
+	return &wrappedUpdatedObjectInfo{objInfo: objInfo, transformers: transformers}
+}
+
+func (i *wrappedUpdatedObjectInfo) UpdatedObject(ctx context.Context, oldObj k8sruntime.Object) (k8sruntime.Object, error) {
+	newObj, err := i.objInfo.UpdatedObject(ctx, oldObj)
+	if err != nil {
+		return nil, err
+	}
+	for _, transformer := range i.transformers {
+		newObj, err = transformer(ctx, newObj, oldObj)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newObj, nil
+}
+
+// storageVerbs returns the discovery verbs for the interfaces the storage
+// implements.
+func storageVerbs(storage Storage) metav1.Verbs {
+	verbs := metav1.Verbs{}
+	if _, ok := storage.(Getter); ok {
+		verbs = append(verbs, "get")
+	}
+	if _, ok := storage.(Lister); ok {
+		verbs = append(verbs, "list")
+	}
+	if _, ok := storage.(Creater); ok {
+		verbs = append(verbs, "create")
+	}
+	if _, ok := storage.(Updater); ok {
+		verbs = append(verbs, "update")
+	}
+	if _, ok := storage.(Patcher); ok {
+		verbs = append(verbs, "patch")
+	}
+	if _, ok := storage.(GracefulDeleter); ok {
+		verbs = append(verbs, "delete")
+	}
+	if _, ok := storage.(Watcher); ok {
+		verbs = append(verbs, "watch")
+	}
+	sort.Strings(verbs)
+	return verbs
+}
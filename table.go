@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metainternalversionscheme "k8s.io/apimachinery/pkg/apis/meta/internalversion/scheme"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1beta1 "k8s.io/apimachinery/pkg/apis/meta/v1beta1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/apiserver/pkg/endpoints/handlers/negotiation"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// printerColumn declares a column of the Table returned for a resource, the
+// same way additionalPrinterColumns do for a CRD. The value of the column is
+// found with JSONPath in the object.
+type printerColumn struct {
+	metav1.TableColumnDefinition
+	JSONPath string
+}
+
+var (
+	_ TableConvertor = (*tableConvertor)(nil)
+
+	// defaultTableConvertor is used for resources whose Storage isn't a
+	// TableConvertor
+	defaultTableConvertor = newTableConvertor()
+)
+
+// tableConvertor converts objects to Tables with a Name column followed by
+// the printer columns it was created with, and an Age column.
+type tableConvertor struct {
+	columns []metav1.TableColumnDefinition
+	paths   []*jsonpath.JSONPath
+}
+
+func newTableConvertor(columns ...printerColumn) *tableConvertor {
+	columns = append(columns, printerColumn{
+		TableColumnDefinition: metav1.TableColumnDefinition{
+			Name:        "Age",
+			Type:        "date",
+			Description: metav1.ObjectMeta{}.SwaggerDoc()["creationTimestamp"],
+		},
+		JSONPath: ".metadata.creationTimestamp",
+	})
+
+	c := &tableConvertor{
+		columns: []metav1.TableColumnDefinition{
+			{
+				Name:        "Name",
+				Type:        "string",
+				Format:      "name",
+				Description: metav1.ObjectMeta{}.SwaggerDoc()["name"],
+			},
+		},
+	}
+	for _, column := range columns {
+		path := jsonpath.New(column.Name).AllowMissingKeys(true)
+		must(path.Parse(fmt.Sprintf("{%s}", column.JSONPath)))
+
+		c.columns = append(c.columns, column.TableColumnDefinition)
+		c.paths = append(c.paths, path)
+	}
+	return c
+}
+
+// ConvertToTable converts an object, or each object of a list, to a row.
+func (c *tableConvertor) ConvertToTable(_ context.Context, obj k8sruntime.Object, tableOptions k8sruntime.Object) (*metav1.Table, error) {
+	table := &metav1.Table{}
+	if options, ok := tableOptions.(*metav1.TableOptions); !ok || !options.NoHeaders {
+		table.ColumnDefinitions = c.columns
+	}
+
+	if list, err := meta.ListAccessor(obj); err == nil {
+		table.ResourceVersion = list.GetResourceVersion()
+		table.Continue = list.GetContinue()
+		table.RemainingItemCount = list.GetRemainingItemCount()
+	} else if object, err := meta.Accessor(obj); err == nil {
+		table.ResourceVersion = object.GetResourceVersion()
+	}
+
+	addRow := func(obj k8sruntime.Object) error {
+		row, err := c.row(obj)
+		if err != nil {
+			return err
+		}
+		table.Rows = append(table.Rows, row)
+		return nil
+	}
+
+	var err error
+	if meta.IsListType(obj) {
+		err = meta.EachListItem(obj, addRow)
+	} else {
+		err = addRow(obj)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+func (c *tableConvertor) row(obj k8sruntime.Object) (metav1.TableRow, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return metav1.TableRow{}, err
+	}
+
+	content, err := k8sruntime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return metav1.TableRow{}, err
+	}
+
+	cells := []interface{}{accessor.GetName()}
+	for i, path := range c.paths {
+		results, err := path.FindResults(content)
+		if err != nil || len(results) == 0 || len(results[0]) == 0 {
+			cells = append(cells, nil)
+			continue
+		}
+		cells = append(cells, cellValue(c.columns[i+1], results[0][0].Interface()))
+	}
+
+	return metav1.TableRow{
+		Cells:  cells,
+		Object: k8sruntime.RawExtension{Object: obj},
+	}, nil
+}
+
+// cellValue formats dates as the time elapsed since then, like kubectl does
+// for the Age column.
+func cellValue(column metav1.TableColumnDefinition, value interface{}) interface{} {
+	if column.Type != "date" {
+		return value
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return value
+	}
+	return duration.HumanDuration(time.Since(t))
+}
+
+// tableRestrictions lets clients ask for a Table in place of the objects
+// returned, which is what kubectl get does.
+type tableRestrictions struct{}
+
+func (tableRestrictions) AllowsMediaTypeTransform(mimeType, mimeSubType string, gvk *schema.GroupVersionKind) bool {
+	if gvk == nil {
+		return true
+	}
+
+	switch gvk.GroupVersion() {
+	case metav1.SchemeGroupVersion, metav1beta1.SchemeGroupVersion:
+	default:
+		return false
+	}
+	return gvk.Kind == "Table" && mimeType == "application" &&
+		(mimeSubType == "json" || mimeSubType == "yaml" || mimeSubType == "vnd.kubernetes.protobuf")
+}
+
+func (tableRestrictions) AllowsServerVersion(string) bool {
+	return false
+}
+
+func (tableRestrictions) AllowsStreamSchema(schema string) bool {
+	return schema == "watch"
+}
+
+// AcceptedTable returns the group version of the Table and the serializer
+// to encode it with, if the request accepts a Table.
+func AcceptedTable(r *http.Request) (schema.GroupVersion, k8sruntime.SerializerInfo, bool) {
+	options, ok := negotiation.NegotiateMediaTypeOptions(r.Header.Get(AcceptHeader), Codecs.SupportedMediaTypes(), tableRestrictions{})
+	if !ok || options.Convert == nil {
+		return schema.GroupVersion{}, k8sruntime.SerializerInfo{}, false
+	}
+	return options.Convert.GroupVersion(), options.Accepted, true
+}
+
+// asTable converts obj to a Table with the TableConvertor of the resource,
+// and keeps in each row what the client asked for with includeObject.
+func asTable(ctx context.Context, scope *requestScope, obj k8sruntime.Object, options *metav1.TableOptions, groupVersion schema.GroupVersion) (*metav1.Table, error) {
+	convertor, ok := scope.Storage.(TableConvertor)
+	if !ok {
+		convertor = defaultTableConvertor
+	}
+
+	table, err := convertor.ConvertToTable(ctx, obj, options)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range table.Rows {
+		row := &table.Rows[i]
+		switch options.IncludeObject {
+		case metav1.IncludeObject:
+			// Rows hold internal objects, which are returned in the version
+			// of the request
+			obj, err := Scheme.ConvertToVersion(row.Object.Object, scope.GroupVersion)
+			if err != nil {
+				return nil, err
+			}
+			row.Object.Object = obj
+		case metav1.IncludeMetadata, "":
+			accessor, err := meta.Accessor(row.Object.Object)
+			if err != nil {
+				return nil, err
+			}
+			partial := meta.AsPartialObjectMetadata(accessor)
+			partial.GetObjectKind().SetGroupVersionKind(groupVersion.WithKind("PartialObjectMetadata"))
+			row.Object.Object = partial
+		case metav1.IncludeNone:
+			row.Object.Object = nil
+		default:
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("unrecognized includeObject value: %q", options.IncludeObject))
+		}
+	}
+
+	return table, nil
+}
+
+// tableOptions returns the TableOptions from the query of the request.
+func tableOptions(r *http.Request) (*metav1.TableOptions, error) {
+	options := &metav1.TableOptions{}
+	// TableOptions are only known to the scheme of the internal version of meta
+	if err := metainternalversionscheme.ParameterCodec.DecodeParameters(r.URL.Query(), metav1.SchemeGroupVersion, options); err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("could not decode table options: %v", err))
+	}
+	return options, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// tokenLength is the number of random bytes of a token
+	tokenLength = 32
+
+	// saltLength is the number of random bytes of the salt of a hash
+	saltLength = 16
+
+	// defaultHashAlgorithm is the algorithm tokens are hashed with, unless
+	// set in hashAlgorithmEnv. Tokens are random, so a salted HMAC is as hard
+	// to reverse as a slow hash.
+	defaultHashAlgorithm = "sha256-hmac"
+)
+
+// hashAlgorithm is an algorithm tokens can be hashed with. Hashes are stored
+// as $<algorithm>$<params>$<salt>$<hash>, where params always hold the
+// version v of the algorithm, so that tokens can be verified after the
+// algorithm or its parameters changed.
+type hashAlgorithm struct {
+	// params are the parameters new hashes are computed with
+	params string
+	hash   func(token, salt []byte, params hashParams) ([]byte, error)
+}
+
+// hashAlgorithms are the algorithms tokens can be hashed with, by name.
+var hashAlgorithms = map[string]hashAlgorithm{
+	"sha256-hmac": {
+		params: "v=1",
+		hash:   hashSHA256HMAC,
+	},
+	"pbkdf2-sha256": {
+		params: "v=1,i=600000",
+		hash:   hashPBKDF2SHA256,
+	},
+	"argon2id": {
+		params: fmt.Sprintf("v=%d,m=65536,t=1,p=4", argon2.Version),
+		hash:   hashArgon2id,
+	},
+}
+
+// hashAlgorithmNames returns the names of the supported algorithms.
+func hashAlgorithmNames() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// generateToken returns a new token from crypto/rand.
+func generateToken() (string, error) {
+	b := make([]byte, tokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "could not generate token")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the salted hash of the token with the given algorithm.
+func hashToken(token string, algorithm string) (string, error) {
+	alg, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return "", errors.Errorf("unsupported hash algorithm %q", algorithm)
+	}
+	params, err := parseHashParams(alg.params)
+	if err != nil {
+		return "", err
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.Wrap(err, "could not generate salt")
+	}
+	hash, err := alg.hash([]byte(token), salt, params)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{
+		"",
+		algorithm,
+		alg.params,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	}, "$"), nil
+}
+
+// verifyToken returns whether the token matches the hash, with the algorithm
+// and parameters the hash was computed with. The parameters can't be higher
+// than those new hashes are computed with, so that stored hashes can't make
+// verifications more costly. Hashes are compared in constant time.
+func verifyToken(token string, hashed string) (bool, error) {
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 5 || parts[0] != "" {
+		return false, errors.New("invalid hash format")
+	}
+	alg, ok := hashAlgorithms[parts[1]]
+	if !ok {
+		return false, errors.Errorf("unsupported hash algorithm %q", parts[1])
+	}
+	params, err := parseHashParams(parts[2])
+	if err != nil {
+		return false, err
+	}
+	limits, err := parseHashParams(alg.params)
+	if err != nil {
+		return false, err
+	}
+	if err := params.atMost(limits); err != nil {
+		return false, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false, errors.Wrap(err, "invalid salt")
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, errors.Wrap(err, "invalid hash")
+	}
+
+	hash, err := alg.hash([]byte(token), salt, params)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(hash, expected) == 1, nil
+}
+
+// tokenLookupHash returns the unsalted SHA-256 of the token, which the
+// Secrets backing tokens are indexed by so that presented tokens can be found
+// without verifying every hash. Tokens are random, so it can't be reversed
+// either, but it's only used for lookups: tokens are still verified against
+// their salted hash.
+func tokenLookupHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// hashParams are the parameters of a hash, eg: v=1,i=600000
+type hashParams map[string]int
+
+func parseHashParams(s string) (hashParams, error) {
+	params := hashParams{}
+	for _, param := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return nil, errors.Errorf("invalid hash parameter %q", param)
+		}
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.Errorf("invalid hash parameter %q", param)
+		}
+		params[key] = i
+	}
+	return params, nil
+}
+
+// get returns the parameter, which must be between low and high.
+func (p hashParams) get(key string, low, high int) (int, error) {
+	value, ok := p[key]
+	if !ok {
+		return 0, errors.Errorf("missing hash parameter %q", key)
+	}
+	if value < low || value > high {
+		return 0, errors.Errorf("hash parameter %s=%d is out of range", key, value)
+	}
+	return value, nil
+}
+
+// atMost returns an error if a parameter is higher than its limit.
+func (p hashParams) atMost(limits hashParams) error {
+	for key, limit := range limits {
+		if value, ok := p[key]; ok && value > limit {
+			return errors.Errorf("hash parameter %s=%d is out of range", key, value)
+		}
+	}
+	return nil
+}
+
+func hashSHA256HMAC(token, salt []byte, params hashParams) ([]byte, error) {
+	if _, err := params.get("v", 1, 1); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(token)
+	return mac.Sum(nil), nil
+}
+
+func hashPBKDF2SHA256(token, salt []byte, params hashParams) ([]byte, error) {
+	if _, err := params.get("v", 1, 1); err != nil {
+		return nil, err
+	}
+	iterations, err := params.get("i", 1, 10_000_000)
+	if err != nil {
+		return nil, err
+	}
+	return pbkdf2.Key(token, salt, iterations, sha256.Size, sha256.New), nil
+}
+
+func hashArgon2id(token, salt []byte, params hashParams) ([]byte, error) {
+	if _, err := params.get("v", argon2.Version, argon2.Version); err != nil {
+		return nil, err
+	}
+	memory, err := params.get("m", 8, 4*1024*1024)
+	if err != nil {
+		return nil, err
+	}
+	time, err := params.get("t", 1, 100)
+	if err != nil {
+		return nil, err
+	}
+	threads, err := params.get("p", 1, 255)
+	if err != nil {
+		return nil, err
+	}
+	return argon2.IDKey(token, salt, uint32(time), uint32(memory), uint8(threads), sha256.Size), nil
+}
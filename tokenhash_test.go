@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestHashToken(t *testing.T) {
+	for _, algorithm := range hashAlgorithmNames() {
+		t.Run(algorithm, func(t *testing.T) {
+			token, err := generateToken()
+			if err != nil {
+				t.Fatal(err)
+			}
+			hashed, err := hashToken(token, algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(hashed, "$"+algorithm+"$"+hashAlgorithms[algorithm].params+"$") {
+				t.Errorf("got hash %s, want a hash with the algorithm and parameters", hashed)
+			}
+			if strings.Contains(hashed, token) {
+				t.Error("the hash contains the token")
+			}
+
+			tests := []struct {
+				name  string
+				token string
+				want  bool
+			}{
+				{name: "token", token: token, want: true},
+				{name: "wrong token", token: token + "x", want: false},
+				{name: "empty token", token: "", want: false},
+			}
+			for _, tt := range tests {
+				ok, err := verifyToken(tt.token, hashed)
+				if err != nil {
+					t.Fatalf("%s: %v", tt.name, err)
+				}
+				if ok != tt.want {
+					t.Errorf("%s: got %t, want %t", tt.name, ok, tt.want)
+				}
+			}
+
+			// The hash is salted
+			rehashed, err := hashToken(token, algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rehashed == hashed {
+				t.Error("the token has the same hash twice")
+			}
+		})
+	}
+}
+
+func TestVerifyTokenMalformedHash(t *testing.T) {
+	salt := base64.RawStdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	hash := base64.RawStdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	tests := []struct {
+		name   string
+		hashed string
+	}{
+		{name: "empty", hashed: ""},
+		{name: "plaintext", hashed: "the-hashed-token"},
+		{name: "missing hash", hashed: "$sha256-hmac$v=1$" + salt},
+		{name: "extra field", hashed: "$sha256-hmac$v=1$" + salt + "$" + hash + "$"},
+		{name: "no leading separator", hashed: "sha256-hmac$v=1$" + salt + "$" + hash + "$"},
+		{name: "unsupported algorithm", hashed: "$md5$v=1$" + salt + "$" + hash},
+		{name: "invalid parameter", hashed: "$sha256-hmac$v$" + salt + "$" + hash},
+		{name: "non-numeric parameter", hashed: "$sha256-hmac$v=one$" + salt + "$" + hash},
+		{name: "unsupported version", hashed: "$sha256-hmac$v=2$" + salt + "$" + hash},
+		{name: "missing parameter", hashed: "$pbkdf2-sha256$v=1$" + salt + "$" + hash},
+		{name: "zero iterations", hashed: "$pbkdf2-sha256$v=1,i=0$" + salt + "$" + hash},
+		{name: "iterations above the limit", hashed: "$pbkdf2-sha256$v=1,i=10000000$" + salt + "$" + hash},
+		{name: "memory above the limit", hashed: "$argon2id$v=19,m=4194304,t=1,p=4$" + salt + "$" + hash},
+		{name: "time above the limit", hashed: "$argon2id$v=19,m=65536,t=100,p=4$" + salt + "$" + hash},
+		{name: "invalid salt", hashed: "$sha256-hmac$v=1$!!!$" + hash},
+		{name: "invalid hash", hashed: "$sha256-hmac$v=1$" + salt + "$!!!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := verifyToken("token", tt.hashed)
+			if err == nil {
+				t.Errorf("got no error, want the hash %q to be invalid", tt.hashed)
+			}
+			if ok {
+				t.Error("the token is verified")
+			}
+		})
+	}
+}
+
+func TestVerifyTokenLowerCost(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	hash, err := hashPBKDF2SHA256([]byte("token"), salt, hashParams{"v": 1, "i": 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := "$pbkdf2-sha256$v=1,i=1000$" + base64.RawStdEncoding.EncodeToString(salt) + "$" + base64.RawStdEncoding.EncodeToString(hash)
+
+	ok, err := verifyToken("token", hashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("the token isn't verified against a hash with fewer iterations")
+	}
+}
+
+func TestHashTokenChangeAlgorithm(t *testing.T) {
+	for _, from := range hashAlgorithmNames() {
+		for _, to := range hashAlgorithmNames() {
+			if from == to {
+				continue
+			}
+			t.Run(from+" to "+to, func(t *testing.T) {
+				token, err := generateToken()
+				if err != nil {
+					t.Fatal(err)
+				}
+				old, err := hashToken(token, from)
+				if err != nil {
+					t.Fatal(err)
+				}
+				upgraded, err := hashToken(token, to)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(upgraded, "$"+to+"$") {
+					t.Errorf("got hash %s, want a %s hash", upgraded, to)
+				}
+
+				// Hashes stored before the algorithm changed are still verified
+				for _, hashed := range []string{old, upgraded} {
+					ok, err := verifyToken(token, hashed)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !ok {
+						t.Errorf("the token isn't verified against %s", hashed)
+					}
+				}
+			})
+		}
+	}
+}
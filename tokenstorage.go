@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	wcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/storage/names"
+	"k8s.io/client-go/util/retry"
+)
+
+// tokenStorage reads and writes the tokens of a kind to the Secrets backing
+// them, and holds the semantics shared by RancherTokens and
+// ClusterRancherTokens. ClusterRancherTokens are handled as RancherTokens in
+// the clusterTokenNamespace.
+type tokenStorage struct {
+	secretClient   wcorev1.SecretController
+	kind           string
+	resource       schema.GroupResource
+	storageVersion schema.GroupVersion
+	hashAlgorithm  string
+}
+
+// updateFunc returns the updated token, given the token currently stored.
+// When the token doesn't exist, it's given an empty token.
+type updateFunc func(ctx context.Context, old *RancherToken) (*RancherToken, error)
+
+// validateCreateFunc and validateUpdateFunc check whether the token can be
+// written once the storage validated it, eg: with validating admission. The
+// token they're given is the token as it will be stored, without the
+// plaintext token.
+type (
+	validateCreateFunc func(token *RancherToken) error
+	validateUpdateFunc func(token, old *RancherToken) error
+)
+
+func newTokenStorage(secretClient wcorev1.SecretController, kind string, resource string, config tokenConfig) *tokenStorage {
+	return &tokenStorage{
+		secretClient:   secretClient,
+		kind:           kind,
+		resource:       Resource(resource),
+		storageVersion: config.storageVersion,
+		hashAlgorithm:  config.hashAlgorithm,
+	}
+}
+
+func (s *tokenStorage) get(namespace, name string) (*RancherToken, error) {
+	_, token, err := getSecretAndToken(s.secretClient, s.kind, namespace, name)
+	if err != nil {
+		return nil, secretError(err, s.resource, s.kind, name)
+	}
+	return token, nil
+}
+
+// create generates the token and stores it. The status of the token is set
+// by the server only, and only the hash of the token is stored: the
+// plaintext token is only returned by create. The name of a token with a
+// generateName is generated like the kube-apiserver does. The token is only
+// created if validateFn, if set, accepts it.
+func (s *tokenStorage) create(token *RancherToken, validateFn validateCreateFunc, dryRun []string) (*RancherToken, error) {
+	if token.Name == "" && token.GenerateName != "" {
+		token.Name = names.SimpleNameGenerator.GenerateName(token.GenerateName)
+	}
+	if errs := validateRancherToken(token); len(errs) > 0 {
+		return nil, apierrors.NewInvalid(Kind(s.kind), token.Name, errs)
+	}
+
+	plaintext, err := generateToken()
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	hashed, err := hashToken(plaintext, s.hashAlgorithm)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	token.Status = RancherTokenStatus{
+		PlaintextToken: plaintext,
+		HashedToken:    hashed,
+	}
+	token.Generation = 1
+	if validateFn != nil {
+		validated := token.DeepCopy()
+		validated.Status.PlaintextToken = ""
+		if err := validateFn(validated); err != nil {
+			return nil, err
+		}
+	}
+
+	secret, err := secretFromToken(token, s.kind, s.storageVersion)
+	if err != nil {
+		return nil, err
+	}
+	secret.StringData[lookupHashKey] = tokenLookupHash(plaintext)
+	created, err := secretWriter(s.secretClient, dryRun).Create(secret)
+	if apierrors.IsAlreadyExists(err) && token.GenerateName != "" {
+		// The generated name is taken, the client can retry
+		return nil, apierrors.NewGenerateNameConflict(s.resource, token.Name, 1)
+	}
+	if err != nil {
+		return nil, secretError(err, s.resource, s.kind, token.Name)
+	}
+	token.Name = created.Name
+	token.UID = created.UID
+	token.ResourceVersion = created.ResourceVersion
+	token.CreationTimestamp = created.CreationTimestamp
+	setTokenExpiry(token, time.Now())
+
+	return token, nil
+}
+
+// update replaces the metadata and spec of the token with those of the
+// updated token. The status and the fields set by the server are kept, and
+// the userID of a token can't be changed. A token that doesn't exist is
+// created, and update returns whether it was.
+//
+// Updates are conditional on the resourceVersion of the backing Secret. A
+// client's stale resourceVersion is a Conflict, and an update that races with
+// another write of the Secret is retried with the latest token when the client
+// didn't set a resourceVersion.
+//
+// The token is only written if validateCreateFn, or validateUpdateFn, if set,
+// accepts it.
+func (s *tokenStorage) update(ctx context.Context, namespace, name string, updateFn updateFunc, validateCreateFn validateCreateFunc, validateUpdateFn validateUpdateFunc, dryRun []string) (*RancherToken, bool, error) {
+	var token *RancherToken
+	var created bool
+	err := retry.OnError(retry.DefaultRetry, isSecretConflict, func() error {
+		var err error
+		token, created, err = s.tryUpdate(ctx, namespace, name, updateFn, validateCreateFn, validateUpdateFn, dryRun)
+		return err
+	})
+	if isSecretConflict(err) {
+		return nil, false, secretError(errors.Cause(err), s.resource, s.kind, name)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return token, created, nil
+}
+
+func (s *tokenStorage) tryUpdate(ctx context.Context, namespace, name string, updateFn updateFunc, validateCreateFn validateCreateFunc, validateUpdateFn validateUpdateFunc, dryRun []string) (*RancherToken, bool, error) {
+	current, old, err := getSecretAndToken(s.secretClient, s.kind, namespace, name)
+	switch {
+	case apierrors.IsNotFound(err):
+		return s.createOnUpdate(ctx, namespace, name, updateFn, validateCreateFn, dryRun)
+	case err != nil:
+		return nil, false, secretError(err, s.resource, s.kind, name)
+	}
+
+	token, err := updateFn(ctx, old.DeepCopy())
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch token.ResourceVersion {
+	case "":
+		token.ResourceVersion = current.ResourceVersion
+	case current.ResourceVersion:
+	default:
+		return nil, false, apierrors.NewConflict(s.resource, name, errors.New(optimisticLockErrorMsg))
+	}
+	token.UID = old.UID
+	token.CreationTimestamp = old.CreationTimestamp
+	token.Status = old.Status
+	// Like the strategies of the kube-apiserver, the generation is only
+	// incremented when the spec changes
+	token.Generation = old.Generation
+	if !apiequality.Semantic.DeepEqual(token.Spec, old.Spec) {
+		token.Generation++
+	}
+	if errs := validateRancherTokenUpdate(token, old); len(errs) > 0 {
+		return nil, false, apierrors.NewInvalid(Kind(s.kind), name, errs)
+	}
+	if validateUpdateFn != nil {
+		if err := validateUpdateFn(token.DeepCopy(), old.DeepCopy()); err != nil {
+			return nil, false, err
+		}
+	}
+
+	secret, err := secretFromToken(token, s.kind, s.storageVersion)
+	if err != nil {
+		return nil, false, err
+	}
+	updated := current.DeepCopy()
+	updated.Labels = secret.Labels
+	updated.Annotations = secret.Annotations
+	updated.Data = secret.Data
+	if lookupHash, ok := current.Data[lookupHashKey]; ok {
+		updated.Data[lookupHashKey] = lookupHash
+	}
+	updated.StringData = secret.StringData
+	updated, err = secretWriter(s.secretClient, dryRun).Update(updated)
+	if err != nil {
+		if apierrors.IsConflict(err) {
+			return nil, false, &secretConflictError{err}
+		}
+		return nil, false, secretError(err, s.resource, s.kind, name)
+	}
+	token.ResourceVersion = updated.ResourceVersion
+	// The TTL may have changed
+	setTokenExpiry(token, time.Now())
+
+	return token, false, nil
+}
+
+// createOnUpdate creates the token of an update when it doesn't exist, like
+// kubectl replace and client-go's Update do for a missing object.
+func (s *tokenStorage) createOnUpdate(ctx context.Context, namespace, name string, updateFn updateFunc, validateFn validateCreateFunc, dryRun []string) (*RancherToken, bool, error) {
+	token, err := updateFn(ctx, &RancherToken{})
+	if err != nil {
+		return nil, false, err
+	}
+	// The client expected to update a version of the token that no longer
+	// exists
+	if token.ResourceVersion != "" {
+		return nil, false, apierrors.NewNotFound(s.resource, name)
+	}
+	token.Namespace = namespace
+
+	token, err = s.create(token, validateFn, dryRun)
+	if err != nil {
+		return nil, false, err
+	}
+	return token, true, nil
+}
+
+// delete deletes the Secret backing the token, provided it's still the Secret
+// that was read and checkFn, if set, accepts the token.
+// preconditionsCheck returns the check of tokenStorage.delete that the token
+// matches the preconditions of the delete. Like the kube-apiserver, tokens that
+// don't match are a Conflict.
+func preconditionsCheck(preconditions *metav1.Preconditions, resource schema.GroupResource) func(*RancherToken) error {
+	return func(token *RancherToken) error {
+		if preconditions == nil {
+			return nil
+		}
+		if preconditions.UID != nil && *preconditions.UID != token.UID {
+			return apierrors.NewConflict(resource, token.Name, errors.Errorf("Precondition failed: UID in precondition: %v, UID in object meta: %v", *preconditions.UID, token.UID))
+		}
+		if preconditions.ResourceVersion != nil && *preconditions.ResourceVersion != token.ResourceVersion {
+			return apierrors.NewConflict(resource, token.Name, errors.Errorf("Precondition failed: ResourceVersion in precondition: %v, ResourceVersion in object meta: %v", *preconditions.ResourceVersion, token.ResourceVersion))
+		}
+		return nil
+	}
+}
+
+func (s *tokenStorage) delete(namespace, name string, checkFn func(*RancherToken) error, dryRun []string) error {
+	secret, token, err := getSecretAndToken(s.secretClient, s.kind, namespace, name)
+	if err != nil {
+		return secretError(err, s.resource, s.kind, name)
+	}
+	if checkFn != nil {
+		if err := checkFn(token); err != nil {
+			return err
+		}
+	}
+
+	options := &metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &secret.UID},
+	}
+	if err := secretWriter(s.secretClient, dryRun).Delete(namespace, name, options); err != nil {
+		return secretError(err, s.resource, s.kind, name)
+	}
+	return nil
+}
+
+// optimisticLockErrorMsg is the message of the Conflict returned for writes
+// with a stale resourceVersion, like the kube-apiserver.
+const optimisticLockErrorMsg = "the object has been modified; please apply your changes to the latest version and try again"
+
+// secretConflictError is a Conflict of a write of a Secret, ie: the Secret
+// changed since it was read.
+type secretConflictError struct {
+	error
+}
+
+func (e *secretConflictError) Cause() error {
+	return e.error
+}
+
+func isSecretConflict(err error) bool {
+	var conflict *secretConflictError
+	return errors.As(err, &conflict)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	wcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fakeSecretClient stores Secrets in memory, setting the fields the
+// kube-apiserver sets on writes. Only the methods used by tokenStorage are
+// implemented.
+type fakeSecretClient struct {
+	wcorev1.SecretController
+
+	lock            sync.Mutex
+	secrets         map[string]*corev1.Secret
+	resourceVersion int
+}
+
+func newFakeSecretClient() *fakeSecretClient {
+	return &fakeSecretClient{secrets: map[string]*corev1.Secret{}}
+}
+
+func (c *fakeSecretClient) Get(namespace, name string, _ metav1.GetOptions) (*corev1.Secret, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secret, ok := c.secrets[namespace+"/"+name]
+	if !ok {
+		return nil, apierrors.NewNotFound(corev1.Resource("secrets"), name)
+	}
+	return secret.DeepCopy(), nil
+}
+
+func (c *fakeSecretClient) Create(secret *corev1.Secret) (*corev1.Secret, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	secret = secret.DeepCopy()
+	key := secret.Namespace + "/" + secret.Name
+	if _, ok := c.secrets[key]; ok {
+		return nil, apierrors.NewAlreadyExists(corev1.Resource("secrets"), secret.Name)
+	}
+	c.resourceVersion++
+	secret.ResourceVersion = strconv.Itoa(c.resourceVersion)
+	secret.UID = types.UID("uid-" + secret.ResourceVersion)
+	secret.Generation = 1
+	secret.CreationTimestamp = metav1.Now()
+	c.store(key, secret)
+	return secret.DeepCopy(), nil
+}
+
+func (c *fakeSecretClient) Update(secret *corev1.Secret) (*corev1.Secret, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := secret.Namespace + "/" + secret.Name
+	current, ok := c.secrets[key]
+	if !ok {
+		return nil, apierrors.NewNotFound(corev1.Resource("secrets"), secret.Name)
+	}
+	if secret.ResourceVersion != current.ResourceVersion {
+		return nil, apierrors.NewConflict(corev1.Resource("secrets"), secret.Name, nil)
+	}
+	secret = secret.DeepCopy()
+	c.resourceVersion++
+	secret.ResourceVersion = strconv.Itoa(c.resourceVersion)
+	c.store(key, secret)
+	return secret.DeepCopy(), nil
+}
+
+func (c *fakeSecretClient) Delete(namespace, name string, _ *metav1.DeleteOptions) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := namespace + "/" + name
+	if _, ok := c.secrets[key]; !ok {
+		return apierrors.NewNotFound(corev1.Resource("secrets"), name)
+	}
+	delete(c.secrets, key)
+	return nil
+}
+
+// store merges the StringData of the Secret into its Data, like the
+// kube-apiserver does.
+func (c *fakeSecretClient) store(key string, secret *corev1.Secret) {
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	for k, v := range secret.StringData {
+		secret.Data[k] = []byte(v)
+	}
+	secret.StringData = nil
+	c.secrets[key] = secret
+}
+
+func newTestTokenStorage(secrets wcorev1.SecretController) *tokenStorage {
+	return newTokenStorage(secrets, "RancherToken", "ranchertokens", tokenConfig{
+		storageVersion: SchemeGroupVersionV1alpha1,
+		hashAlgorithm:  defaultHashAlgorithm,
+	})
+}
+
+func TestTokenStorageCreate(t *testing.T) {
+	tests := []struct {
+		name       string
+		objectMeta metav1.ObjectMeta
+		dryRun     []string
+	}{
+		{
+			name:       "name",
+			objectMeta: metav1.ObjectMeta{Name: "token", Namespace: "default"},
+		},
+		{
+			name:       "generateName",
+			objectMeta: metav1.ObjectMeta{GenerateName: "token-", Namespace: "default"},
+		},
+		{
+			name:       "generateName dry-run",
+			objectMeta: metav1.ObjectMeta{GenerateName: "token-", Namespace: "default"},
+			dryRun:     []string{metav1.DryRunAll},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secrets := newFakeSecretClient()
+			tokens := newTestTokenStorage(secrets)
+
+			token, err := tokens.create(&RancherToken{
+				ObjectMeta: tt.objectMeta,
+				Spec:       RancherTokenSpec{UserID: "user"},
+			}, nil, tt.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.Name == "" || !strings.HasPrefix(token.Name, tt.objectMeta.GenerateName) {
+				t.Fatalf("got name %q, want a name generated from %q", token.Name, tt.objectMeta.GenerateName)
+			}
+			if token.Status.PlaintextToken == "" {
+				t.Error("the plaintext token isn't returned")
+			}
+
+			secret, err := secrets.Get("default", token.Name, metav1.GetOptions{})
+			if len(tt.dryRun) > 0 {
+				if !apierrors.IsNotFound(err) {
+					t.Fatalf("got error %v, want the dry-run create not to be persisted", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("the created token %s can't be found: %v", token.Name, err)
+			}
+			if token.UID != secret.UID || token.ResourceVersion != secret.ResourceVersion {
+				t.Errorf("got uid %s and resourceVersion %s, want those of the Secret: %s and %s",
+					token.UID, token.ResourceVersion, secret.UID, secret.ResourceVersion)
+			}
+
+			stored, err := tokens.get("default", token.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.Generation != 1 || stored.Generation != token.Generation {
+				t.Errorf("got generation %d, stored as %d, want 1", token.Generation, stored.Generation)
+			}
+			if stored.Status.PlaintextToken != "" {
+				t.Error("the plaintext token is stored")
+			}
+		})
+	}
+}
@@ -10,7 +10,12 @@ var (
 	RancherTokenName = "ranchertokens"
 )
 
-var SchemeGroupVersion = schema.GroupVersion{Group: "tomlebreux.com", Version: "v1alpha1"}
+// GroupName is the group of every served version
+const GroupName = "tomlebreux.com"
+
+// SchemeGroupVersion is the internal version of the group, which every served
+// version is converted to and from. Storages work with internal objects.
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: runtime.APIVersionInternal}
 
 func Kind(kind string) schema.GroupKind {
 	return SchemeGroupVersion.WithKind(kind).GroupKind()
@@ -21,16 +26,39 @@ func Resource(resource string) schema.GroupResource {
 }
 
 var (
-	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
-	AddToScheme   = SchemeBuilder.AddToScheme
+	SchemeBuilder = runtime.NewSchemeBuilder(
+		addKnownTypes,
+		addKnownTypesV1alpha1,
+		addKnownTypesV1beta1,
+
+		conversionFunc(convertRancherTokenV1alpha1ToInternal),
+		conversionFunc(convertRancherTokenInternalToV1alpha1),
+		conversionFunc(convertRancherTokenListV1alpha1ToInternal),
+		conversionFunc(convertRancherTokenListInternalToV1alpha1),
+		conversionFunc(convertClusterRancherTokenV1alpha1ToInternal),
+		conversionFunc(convertClusterRancherTokenInternalToV1alpha1),
+		conversionFunc(convertClusterRancherTokenListV1alpha1ToInternal),
+		conversionFunc(convertClusterRancherTokenListInternalToV1alpha1),
+
+		conversionFunc(convertRancherTokenV1beta1ToInternal),
+		conversionFunc(convertRancherTokenInternalToV1beta1),
+		conversionFunc(convertRancherTokenListV1beta1ToInternal),
+		conversionFunc(convertRancherTokenListInternalToV1beta1),
+		conversionFunc(convertClusterRancherTokenV1beta1ToInternal),
+		conversionFunc(convertClusterRancherTokenInternalToV1beta1),
+		conversionFunc(convertClusterRancherTokenListV1beta1ToInternal),
+		conversionFunc(convertClusterRancherTokenListInternalToV1beta1),
+	)
+	AddToScheme = SchemeBuilder.AddToScheme
 )
 
 func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&RancherToken{},
+		&RancherTokenList{},
 		&ClusterRancherToken{},
+		&ClusterRancherTokenList{},
 	)
-	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
 }
 
@@ -45,15 +73,24 @@ type RancherToken struct {
 }
 
 type RancherTokenSpec struct {
-	UserID      string `json:"userID"`
-	ClusterName string `json:"clusterName"`
-	TTL         string `json:"ttl"`
-	Enabled     string `json:"enabled"`
+	UserID      string          `json:"userID"`
+	ClusterName string          `json:"clusterName"`
+	TTL         metav1.Duration `json:"ttl"`
+	Enabled     bool            `json:"enabled"`
 }
 
 type RancherTokenStatus struct {
-	PlaintextToken string `json:"plaintextToken,omitempty"`
-	HashedToken    string `json:"hashedToken"`
+	PlaintextToken string       `json:"plaintextToken,omitempty"`
+	HashedToken    string       `json:"hashedToken"`
+	ExpiresAt      *metav1.Time `json:"expiresAt,omitempty"`
+	Expired        bool         `json:"expired"`
+}
+
+func (in *RancherTokenStatus) DeepCopyInto(out *RancherTokenStatus) {
+	*out = *in
+	if in.ExpiresAt != nil {
+		out.ExpiresAt = in.ExpiresAt.DeepCopy()
+	}
 }
 
 func (in *RancherToken) DeepCopyInto(out *RancherToken) {
@@ -61,7 +98,7 @@ func (in *RancherToken) DeepCopyInto(out *RancherToken) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 func (in *RancherToken) DeepCopy() *RancherToken {
@@ -80,6 +117,43 @@ func (r *RancherToken) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+var _ runtime.Object = (*RancherTokenList)(nil)
+
+type RancherTokenList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []RancherToken `json:"items"`
+}
+
+func (in *RancherTokenList) DeepCopyInto(out *RancherTokenList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]RancherToken, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *RancherTokenList) DeepCopy() *RancherTokenList {
+	if in == nil {
+		return nil
+	}
+	out := new(RancherTokenList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (r *RancherTokenList) DeepCopyObject() runtime.Object {
+	if c := r.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
 var _ runtime.Object = (*ClusterRancherToken)(nil)
 
 type ClusterRancherToken struct {
@@ -95,7 +169,7 @@ func (in *ClusterRancherToken) DeepCopyInto(out *ClusterRancherToken) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 func (in *ClusterRancherToken) DeepCopy() *ClusterRancherToken {
@@ -113,3 +187,40 @@ func (r *ClusterRancherToken) DeepCopyObject() runtime.Object {
 	}
 	return nil
 }
+
+var _ runtime.Object = (*ClusterRancherTokenList)(nil)
+
+type ClusterRancherTokenList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ClusterRancherToken `json:"items"`
+}
+
+func (in *ClusterRancherTokenList) DeepCopyInto(out *ClusterRancherTokenList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]ClusterRancherToken, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *ClusterRancherTokenList) DeepCopy() *ClusterRancherTokenList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterRancherTokenList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (r *ClusterRancherTokenList) DeepCopyObject() runtime.Object {
+	if c := r.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
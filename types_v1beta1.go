@@ -0,0 +1,215 @@
+package main
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var SchemeGroupVersionV1beta1 = schema.GroupVersion{Group: GroupName, Version: "v1beta1"}
+
+// addKnownTypesV1beta1 registers the v1beta1 types under the names of their kinds,
+// since the Go types of each version can't share the same name.
+func addKnownTypesV1beta1(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypeWithName(SchemeGroupVersionV1beta1.WithKind("RancherToken"), &RancherTokenV1beta1{})
+	scheme.AddKnownTypeWithName(SchemeGroupVersionV1beta1.WithKind("RancherTokenList"), &RancherTokenListV1beta1{})
+	scheme.AddKnownTypeWithName(SchemeGroupVersionV1beta1.WithKind("ClusterRancherToken"), &ClusterRancherTokenV1beta1{})
+	scheme.AddKnownTypeWithName(SchemeGroupVersionV1beta1.WithKind("ClusterRancherTokenList"), &ClusterRancherTokenListV1beta1{})
+	metav1.AddToGroupVersion(scheme, SchemeGroupVersionV1beta1)
+	return nil
+}
+
+var _ runtime.Object = (*RancherTokenV1beta1)(nil)
+
+// RancherTokenV1beta1 is a token of a user, optionally scoped to a
+// cluster, in the namespace of the user.
+// +k8s:openapi-gen=true
+type RancherTokenV1beta1 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec is the desired state of the token.
+	Spec RancherTokenSpecV1beta1 `json:"spec"`
+	// Status is the state of the token, set by the server.
+	Status RancherTokenStatusV1beta1 `json:"status"`
+}
+
+// RancherTokenSpecV1beta1 is the desired state of a token.
+// +k8s:openapi-gen=true
+type RancherTokenSpecV1beta1 struct {
+	// UserID is the user the token belongs to.
+	UserID string `json:"userID"`
+	// ClusterName is the cluster the token is scoped to, if any.
+	// +optional
+	ClusterName string `json:"clusterName,omitempty"`
+	// TTL is how long the token is valid for, such as "1h30m". The token
+	// doesn't expire if it is not set.
+	// +optional
+	TTL metav1.Duration `json:"ttl,omitempty"`
+	// Enabled is whether the token can be used.
+	Enabled bool `json:"enabled"`
+}
+
+// RancherTokenStatusV1beta1 is the state of a token, set by the server.
+// +k8s:openapi-gen=true
+type RancherTokenStatusV1beta1 struct {
+	// PlaintextToken is the token, which is only returned when the token is
+	// created.
+	PlaintextToken string `json:"plaintextToken,omitempty"`
+	// HashedToken is the hash of the token.
+	HashedToken string `json:"hashedToken"`
+	// ExpiresAt is when the token expires, if it has a TTL.
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+	// Expired is whether the token has expired.
+	Expired bool `json:"expired"`
+}
+
+func (in *RancherTokenStatusV1beta1) DeepCopyInto(out *RancherTokenStatusV1beta1) {
+	*out = *in
+	if in.ExpiresAt != nil {
+		out.ExpiresAt = in.ExpiresAt.DeepCopy()
+	}
+}
+
+func (in *RancherTokenV1beta1) DeepCopyInto(out *RancherTokenV1beta1) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+func (in *RancherTokenV1beta1) DeepCopy() *RancherTokenV1beta1 {
+	if in == nil {
+		return nil
+	}
+	out := new(RancherTokenV1beta1)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (r *RancherTokenV1beta1) DeepCopyObject() runtime.Object {
+	if c := r.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+var _ runtime.Object = (*RancherTokenListV1beta1)(nil)
+
+// RancherTokenListV1beta1 is a list of RancherTokens.
+// +k8s:openapi-gen=true
+type RancherTokenListV1beta1 struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	// Items is the list of tokens.
+	Items []RancherTokenV1beta1 `json:"items"`
+}
+
+func (in *RancherTokenListV1beta1) DeepCopyInto(out *RancherTokenListV1beta1) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]RancherTokenV1beta1, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *RancherTokenListV1beta1) DeepCopy() *RancherTokenListV1beta1 {
+	if in == nil {
+		return nil
+	}
+	out := new(RancherTokenListV1beta1)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (r *RancherTokenListV1beta1) DeepCopyObject() runtime.Object {
+	if c := r.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+var _ runtime.Object = (*ClusterRancherTokenV1beta1)(nil)
+
+// ClusterRancherTokenV1beta1 is a token of a user, optionally scoped to a
+// cluster, that isn't in any namespace.
+// +k8s:openapi-gen=true
+type ClusterRancherTokenV1beta1 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec is the desired state of the token.
+	Spec RancherTokenSpecV1beta1 `json:"spec"`
+	// Status is the state of the token, set by the server.
+	Status RancherTokenStatusV1beta1 `json:"status"`
+}
+
+func (in *ClusterRancherTokenV1beta1) DeepCopyInto(out *ClusterRancherTokenV1beta1) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+func (in *ClusterRancherTokenV1beta1) DeepCopy() *ClusterRancherTokenV1beta1 {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterRancherTokenV1beta1)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (r *ClusterRancherTokenV1beta1) DeepCopyObject() runtime.Object {
+	if c := r.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+var _ runtime.Object = (*ClusterRancherTokenListV1beta1)(nil)
+
+// ClusterRancherTokenListV1beta1 is a list of ClusterRancherTokens.
+// +k8s:openapi-gen=true
+type ClusterRancherTokenListV1beta1 struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	// Items is the list of tokens.
+	Items []ClusterRancherTokenV1beta1 `json:"items"`
+}
+
+func (in *ClusterRancherTokenListV1beta1) DeepCopyInto(out *ClusterRancherTokenListV1beta1) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]ClusterRancherTokenV1beta1, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *ClusterRancherTokenListV1beta1) DeepCopy() *ClusterRancherTokenListV1beta1 {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterRancherTokenListV1beta1)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (r *ClusterRancherTokenListV1beta1) DeepCopyObject() runtime.Object {
+	if c := r.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
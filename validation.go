@@ -0,0 +1,43 @@
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// validateRancherToken returns the errors of the fields of the token that
+// clients can set.
+func validateRancherToken(token *RancherToken) field.ErrorList {
+	metaPath := field.NewPath("metadata")
+	errs := validation.ValidateObjectMeta(&token.ObjectMeta, true, validation.NameIsDNSSubdomain, metaPath)
+	// Finalizers and ownerReferences would be set on the Secret, where nothing
+	// removes them from tokens being deleted
+	if len(token.Finalizers) > 0 {
+		errs = append(errs, field.Forbidden(metaPath.Child("finalizers"), "tokens don't support finalizers"))
+	}
+	if len(token.OwnerReferences) > 0 {
+		errs = append(errs, field.Forbidden(metaPath.Child("ownerReferences"), "tokens don't support ownerReferences"))
+	}
+	errs = append(errs, validateRancherTokenSpec(&token.Spec, field.NewPath("spec"))...)
+	return errs
+}
+
+func validateRancherTokenSpec(spec *RancherTokenSpec, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if spec.UserID == "" {
+		errs = append(errs, field.Required(path.Child("userID"), ""))
+	}
+	if spec.TTL.Duration < 0 {
+		errs = append(errs, field.Invalid(path.Child("ttl"), spec.TTL.Duration.String(), "must not be negative"))
+	}
+	return errs
+}
+
+// validateRancherTokenUpdate returns the errors of the token, and of the
+// fields that can't be changed once the token is created.
+func validateRancherTokenUpdate(token, old *RancherToken) field.ErrorList {
+	errs := validation.ValidateObjectMetaUpdate(&token.ObjectMeta, &old.ObjectMeta, field.NewPath("metadata"))
+	errs = append(errs, validateRancherToken(token)...)
+	errs = append(errs, validation.ValidateImmutableField(token.Spec.UserID, old.Spec.UserID, field.NewPath("spec", "userID"))...)
+	return errs
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"agones.dev/agones/pkg/util/runtime"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// eventHistorySize is the number of Secret events kept to serve watches
+	// that start from an older resourceVersion
+	eventHistorySize = 1000
+	// watchQueueSize is the number of events that can be pending for a
+	// single watch before it is considered too slow and is terminated
+	watchQueueSize = 100
+	// bookmarkInterval is how often bookmarks are sent to watches that
+	// allow them
+	bookmarkInterval = time.Minute
+)
+
+// secretEvent is a change to a Secret observed by the informer.
+type secretEvent struct {
+	Type            watch.EventType
+	Secret          *corev1.Secret
+	OldSecret       *corev1.Secret
+	ResourceVersion uint64
+}
+
+// secretWatchCache keeps the Secrets seen by the informer along with a
+// history of their recent events, so that watches can be started from the
+// current state or from a recent resourceVersion.
+type secretWatchCache struct {
+	logger *logrus.Entry
+	synced func() bool
+
+	lock    sync.Mutex
+	secrets map[string]*corev1.Secret
+	events  []secretEvent
+	// historyStart is the resourceVersion from which no event is missing
+	// from the history
+	historyStart    uint64
+	resourceVersion uint64
+	watches         map[*secretWatch]struct{}
+}
+
+func newSecretWatchCache(informer cache.SharedIndexInformer) (*secretWatchCache, error) {
+	c := &secretWatchCache{
+		secrets: map[string]*corev1.Secret{},
+		watches: map[*secretWatch]struct{}{},
+	}
+	c.logger = runtime.NewLoggerWithType(c)
+
+	registration, err := informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if secret, ok := obj.(*corev1.Secret); ok {
+				c.process(watch.Added, nil, secret, isInInitialList)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSecret, ok := oldObj.(*corev1.Secret)
+			if !ok {
+				return
+			}
+			if secret, ok := newObj.(*corev1.Secret); ok {
+				c.process(watch.Modified, oldSecret, secret, false)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if secret, ok := obj.(*corev1.Secret); ok {
+				c.process(watch.Deleted, nil, secret, false)
+			}
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.synced = registration.HasSynced
+
+	return c, nil
+}
+
+// process records the event and sends it to every watch. Secrets from the
+// initial list of the informer are not recorded as events since the history
+// only starts after them.
+func (c *secretWatchCache) process(eventType watch.EventType, oldSecret, secret *corev1.Secret, isInInitialList bool) {
+	resourceVersion, err := strconv.ParseUint(secret.ResourceVersion, 10, 64)
+	if err != nil {
+		c.logger.WithError(err).WithField("secret", secret.Name).Warn("Ignoring Secret with invalid resourceVersion")
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := secret.Namespace + "/" + secret.Name
+	if eventType == watch.Deleted {
+		delete(c.secrets, key)
+	} else {
+		c.secrets[key] = secret
+	}
+
+	if isInInitialList {
+		c.historyStart = max(c.historyStart, resourceVersion)
+		c.resourceVersion = max(c.resourceVersion, resourceVersion)
+		return
+	}
+
+	// Resyncs are reported as updates without any change
+	if eventType == watch.Modified && oldSecret.ResourceVersion == secret.ResourceVersion {
+		return
+	}
+
+	event := secretEvent{
+		Type:            eventType,
+		Secret:          secret,
+		OldSecret:       oldSecret,
+		ResourceVersion: resourceVersion,
+	}
+	if len(c.events) == eventHistorySize {
+		c.historyStart = c.events[0].ResourceVersion
+		c.events = c.events[1:]
+	}
+	c.events = append(c.events, event)
+	c.resourceVersion = max(c.resourceVersion, resourceVersion)
+
+	for w := range c.watches {
+		w.enqueue(event)
+	}
+}
+
+// Watch returns a watch of the Secrets matched by filter, converted with
+// convert, as described by the options. Events for Secrets that stop or
+// start matching the label and field selectors are sent as DELETED and
+// ADDED events respectively.
+func (c *secretWatchCache) Watch(filter func(*corev1.Secret) bool, convert func(*corev1.Secret) (k8sruntime.Object, error), options *metav1.ListOptions) (watch.Interface, error) {
+	if !c.synced() {
+		return nil, apierrors.NewTooManyRequests("storage is (re)initializing", 1)
+	}
+
+	label, err := labels.Parse(options.LabelSelector)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	field, err := parseFieldSelector(options.FieldSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &secretWatch{
+		cache:   c,
+		filter:  filter,
+		convert: convert,
+		label:   label,
+		field:   field,
+		queue:   make(chan secretEvent, watchQueueSize),
+		result:  make(chan watch.Event),
+		done:    make(chan struct{}),
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var initial []watch.Event
+	switch options.ResourceVersion {
+	case "", "0":
+		// Start with the current state of every matching Secret
+		for _, secret := range c.secrets {
+			if obj, ok := w.matches(secret); ok {
+				initial = append(initial, watch.Event{Type: watch.Added, Object: obj})
+			}
+		}
+	default:
+		resourceVersion, err := strconv.ParseUint(options.ResourceVersion, 10, 64)
+		if err != nil {
+			return nil, apierrors.NewBadRequest("invalid resourceVersion: " + options.ResourceVersion)
+		}
+		w.resourceVersion = resourceVersion
+		if resourceVersion < c.historyStart {
+			initial = append(initial, watch.Event{
+				Type:   watch.Error,
+				Object: &apierrors.NewResourceExpired("too old resource version: " + options.ResourceVersion).ErrStatus,
+			})
+			close(w.queue)
+			go w.run(initial)
+			return w, nil
+		}
+		// Replay the events that happened after the given resourceVersion
+		for _, event := range c.events {
+			if event.ResourceVersion <= resourceVersion {
+				continue
+			}
+			if e, ok := w.event(event); ok {
+				initial = append(initial, e)
+			}
+		}
+	}
+
+	if options.AllowWatchBookmarks {
+		w.bookmarks = time.NewTicker(bookmarkInterval)
+	}
+	c.watches[w] = struct{}{}
+
+	go w.run(initial)
+	return w, nil
+}
+
+// bookmark queues a bookmark with the current resourceVersion of the cache.
+// All events up to that resourceVersion have been queued before it.
+func (c *secretWatchCache) bookmark(w *secretWatch) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.watches[w]; !ok {
+		return
+	}
+	w.enqueue(secretEvent{
+		Type:            watch.Bookmark,
+		ResourceVersion: c.resourceVersion,
+	})
+}
+
+// forget stops sending events to the watch.
+func (c *secretWatchCache) forget(w *secretWatch) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.watches[w]; ok {
+		delete(c.watches, w)
+		close(w.queue)
+	}
+}
+
+var _ watch.Interface = (*secretWatch)(nil)
+
+// secretWatch is a single watch started from a secretWatchCache.
+type secretWatch struct {
+	cache     *secretWatchCache
+	filter    func(*corev1.Secret) bool
+	convert   func(*corev1.Secret) (k8sruntime.Object, error)
+	label     labels.Selector
+	field     fields.Selector
+	bookmarks *time.Ticker
+	// resourceVersion is the resourceVersion the watch started from, which
+	// bookmarks never go below
+	resourceVersion uint64
+
+	queue    chan secretEvent
+	result   chan watch.Event
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// enqueue must be called with the lock of the cache held. A watch that can't
+// keep up with the events is terminated, and the client is expected to
+// start a new one.
+func (w *secretWatch) enqueue(event secretEvent) {
+	select {
+	case w.queue <- event:
+	default:
+		delete(w.cache.watches, w)
+		close(w.queue)
+	}
+}
+
+func (w *secretWatch) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+		w.cache.forget(w)
+	})
+}
+
+func (w *secretWatch) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+func (w *secretWatch) run(initial []watch.Event) {
+	defer close(w.result)
+	defer w.Stop()
+
+	var bookmarks <-chan time.Time
+	if w.bookmarks != nil {
+		defer w.bookmarks.Stop()
+		bookmarks = w.bookmarks.C
+	}
+
+	for _, event := range initial {
+		if !w.send(event) {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-bookmarks:
+			w.cache.bookmark(w)
+		case event, ok := <-w.queue:
+			if !ok {
+				return
+			}
+			if e, ok := w.event(event); ok && !w.send(e) {
+				return
+			}
+		}
+	}
+}
+
+func (w *secretWatch) send(event watch.Event) bool {
+	select {
+	case <-w.done:
+		return false
+	case w.result <- event:
+		return true
+	}
+}
+
+// event converts the Secret event to the event sent to the client, if any.
+func (w *secretWatch) event(event secretEvent) (watch.Event, bool) {
+	if event.Type == watch.Bookmark {
+		obj, err := w.convert(&corev1.Secret{})
+		if err != nil {
+			return watch.Event{}, false
+		}
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return watch.Event{}, false
+		}
+		accessor.SetResourceVersion(strconv.FormatUint(max(event.ResourceVersion, w.resourceVersion), 10))
+		return watch.Event{Type: watch.Bookmark, Object: obj}, true
+	}
+
+	obj, matches := w.matches(event.Secret)
+	if event.Type != watch.Modified {
+		return watch.Event{Type: event.Type, Object: obj}, matches
+	}
+
+	oldObj, matched := w.matches(event.OldSecret)
+	switch {
+	case matched && matches:
+		return watch.Event{Type: watch.Modified, Object: obj}, true
+	case matches:
+		return watch.Event{Type: watch.Added, Object: obj}, true
+	case matched:
+		// The Secret may not be a token anymore, so like the kube-apiserver's
+		// watch cache, the object that stopped matching is sent at the
+		// resourceVersion of the event
+		if accessor, err := meta.Accessor(oldObj); err == nil {
+			accessor.SetResourceVersion(event.Secret.ResourceVersion)
+		}
+		return watch.Event{Type: watch.Deleted, Object: oldObj}, true
+	default:
+		return watch.Event{}, false
+	}
+}
+
+// matches converts the Secret and returns whether it matches the filter and
+// the selectors of the watch.
+// parseFieldSelector parses the field selector of a list or watch. Like for
+// custom resources, only metadata.name and metadata.namespace are supported.
+func parseFieldSelector(selector string) (fields.Selector, error) {
+	field, err := fields.ParseSelector(selector)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	for _, requirement := range field.Requirements() {
+		switch requirement.Field {
+		case "metadata.name", "metadata.namespace":
+		default:
+			return nil, apierrors.NewBadRequest("field label not supported: " + requirement.Field)
+		}
+	}
+	return field, nil
+}
+
+func (w *secretWatch) matches(secret *corev1.Secret) (k8sruntime.Object, bool) {
+	if !w.filter(secret) {
+		return nil, false
+	}
+
+	obj, err := w.convert(secret)
+	if err != nil {
+		w.cache.logger.WithError(err).WithField("secret", secret.Name).Warn("Ignoring Secret that can't be converted")
+		return nil, false
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, false
+	}
+
+	if !w.label.Matches(labels.Set(accessor.GetLabels())) {
+		return obj, false
+	}
+	return obj, w.field.Matches(fields.Set{
+		"metadata.name":      accessor.GetName(),
+		"metadata.namespace": accessor.GetNamespace(),
+	})
+}
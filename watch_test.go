@@ -0,0 +1,152 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newTestSecretWatchCache returns a secretWatchCache that is fed events with
+// process instead of an informer.
+func newTestSecretWatchCache(t *testing.T) *secretWatchCache {
+	t.Helper()
+	informer := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0).Core().V1().Secrets().Informer()
+	c, err := newSecretWatchCache(informer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.synced = func() bool { return true }
+	return c
+}
+
+func testTokenSecret(t *testing.T, name, resourceVersion string, labels map[string]string) *corev1.Secret {
+	t.Helper()
+	token := &RancherToken{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			ResourceVersion: resourceVersion,
+			Labels:          labels,
+		},
+		Spec: RancherTokenSpec{UserID: "user"},
+	}
+	secret, err := secretFromToken(token, "RancherToken", SchemeGroupVersionV1alpha1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range secret.StringData {
+		secret.Data[key] = []byte(value)
+	}
+	secret.StringData = nil
+	return secret
+}
+
+func nextWatchEvent(t *testing.T, w watch.Interface) watch.Event {
+	t.Helper()
+	select {
+	case event, ok := <-w.ResultChan():
+		if !ok {
+			t.Fatal("watch ended")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a watch event")
+	}
+	return watch.Event{}
+}
+
+func TestSecretWatchStopsMatching(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		update   func(*corev1.Secret)
+	}{
+		{
+			name:   "kind label removed",
+			update: func(secret *corev1.Secret) { delete(secret.Labels, tokenKindLabel) },
+		},
+		{
+			name:     "label selector",
+			selector: "team=a",
+			update:   func(secret *corev1.Secret) { secret.Labels["team"] = "b" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestSecretWatchCache(t)
+			filter := func(secret *corev1.Secret) bool {
+				return secret.Labels[tokenKindLabel] == "RancherToken"
+			}
+			convert := func(secret *corev1.Secret) (k8sruntime.Object, error) {
+				return tokenFromSecret(secret)
+			}
+			w, err := c.Watch(filter, convert, &metav1.ListOptions{LabelSelector: tt.selector})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Stop()
+
+			secret := testTokenSecret(t, "token", "1", map[string]string{"team": "a"})
+			c.process(watch.Added, nil, secret, false)
+			if event := nextWatchEvent(t, w); event.Type != watch.Added {
+				t.Fatalf("got %s event, want ADDED", event.Type)
+			}
+
+			updated := secret.DeepCopy()
+			updated.ResourceVersion = "2"
+			tt.update(updated)
+			c.process(watch.Modified, secret, updated, false)
+
+			event := nextWatchEvent(t, w)
+			if event.Type != watch.Deleted {
+				t.Fatalf("got %s event, want DELETED", event.Type)
+			}
+			token, ok := event.Object.(*RancherToken)
+			if !ok {
+				t.Fatalf("got %T object, want *RancherToken", event.Object)
+			}
+			if token.Name != "token" || token.ResourceVersion != "2" {
+				t.Errorf("got token %s at resourceVersion %s, want token at resourceVersion 2", token.Name, token.ResourceVersion)
+			}
+		})
+	}
+}
+
+func TestSecretWatchFieldSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		wantErr  bool
+	}{
+		{selector: "metadata.name=token"},
+		{selector: "metadata.namespace=default,metadata.name!=token"},
+		{selector: "spec.userID=user", wantErr: true},
+		{selector: "type=Opaque", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			c := newTestSecretWatchCache(t)
+			filter := func(*corev1.Secret) bool { return true }
+			convert := func(secret *corev1.Secret) (k8sruntime.Object, error) {
+				return tokenFromSecret(secret)
+			}
+			w, err := c.Watch(filter, convert, &metav1.ListOptions{FieldSelector: tt.selector})
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+				w.Stop()
+				return
+			}
+			if !apierrors.IsBadRequest(err) {
+				t.Fatalf("got error %v, want BadRequest", err)
+			}
+		})
+	}
+}
@@ -2,21 +2,38 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 
 	wadmission "github.com/rancher/wrangler/v3/pkg/generated/controllers/admissionregistration.k8s.io/v1"
+	wcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	admissionreviewv1 "k8s.io/api/admission/v1"
 	admissionv1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/authentication"
 )
 
 var (
 	mutatingHook = &webhook.Admission{
 		Handler: admission.HandlerFunc(func(ctx context.Context, req webhook.AdmissionRequest) webhook.AdmissionResponse {
-			fmt.Println("HITHERE mutating webhook")
+			// There is no object to patch on deletes
+			if req.Operation == admissionreviewv1.Delete {
+				return webhook.Allowed("")
+			}
+			obj := &metav1.PartialObjectMetadata{}
+			if err := json.Unmarshal(req.Object.Raw, obj); err != nil {
+				return webhook.Errored(http.StatusBadRequest, err)
+			}
+			// Adding to annotations that don't exist fails
+			if obj.Annotations == nil {
+				return webhook.Patched("some changes",
+					webhook.JSONPatchOp{Operation: "add", Path: "/metadata/annotations", Value: map[string]string{"access": "granted", "reason": "not blocked"}},
+				)
+			}
 			return webhook.Patched("some changes",
 				webhook.JSONPatchOp{Operation: "add", Path: "/metadata/annotations/access", Value: "granted"},
 				webhook.JSONPatchOp{Operation: "add", Path: "/metadata/annotations/reason", Value: "not blocked"},
@@ -26,7 +43,6 @@ var (
 
 	validatingHook = &webhook.Admission{
 		Handler: admission.HandlerFunc(func(ctx context.Context, req webhook.AdmissionRequest) webhook.AdmissionResponse {
-			fmt.Println("HITHERE validating webhook")
 			if req.Name == "blocked" {
 				return webhook.Denied("none shall pass!")
 			}
@@ -37,6 +53,7 @@ var (
 
 func setupWebhook(
 	mux *http.ServeMux,
+	secretClient wcorev1.SecretController,
 ) error {
 	mutatingHookHandler, err := admission.StandaloneWebhook(mutatingHook, admission.StandaloneOptions{})
 	if err != nil {
@@ -51,13 +68,15 @@ func setupWebhook(
 	// Register the webhook handlers to your server
 	mux.Handle("/mutating", mutatingHookHandler)
 	mux.Handle("/validating", validatingHookHandler)
+	// TokenReviews of kube-apiservers authenticating with RancherTokens
+	mux.Handle("/authenticate", &authentication.Webhook{Handler: newTokenAuthenticator(secretClient)})
 	return nil
 }
 
 func syncMutatingWebhook(mutatingClient wadmission.MutatingWebhookConfigurationController, secret *corev1.Secret) error {
 	it := &admissionv1.MutatingWebhookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: fmt.Sprintf("%s.%s", SchemeGroupVersion.Version, SchemeGroupVersion.Group),
+			Name: fmt.Sprintf("%s.%s", SchemeGroupVersionV1alpha1.Version, SchemeGroupVersionV1alpha1.Group),
 		},
 	}
 	err := CreateOrUpdate(it, mutatingClient, func(mutating *admissionv1.MutatingWebhookConfiguration) {
@@ -69,6 +88,7 @@ func syncMutatingWebhook(mutatingClient wadmission.MutatingWebhookConfigurationC
 						Namespace: namespace,
 						Name:      serviceName,
 						Path:      ptr("/mutating"),
+						Port:      ptr(int32(defaultHTTPSPort)),
 					},
 					CABundle: secret.Data[corev1.TLSCertKey],
 				},
@@ -76,7 +96,7 @@ func syncMutatingWebhook(mutatingClient wadmission.MutatingWebhookConfigurationC
 					{
 						Operations: []admissionv1.OperationType{"*"},
 						Rule: admissionv1.Rule{
-							APIGroups:   []string{"tomlebreux.com"},
+							APIGroups:   []string{GroupName},
 							APIVersions: []string{"*"},
 							Resources:   []string{"*"},
 						},
@@ -93,7 +113,7 @@ func syncMutatingWebhook(mutatingClient wadmission.MutatingWebhookConfigurationC
 func syncValidatingWebhook(validatingClient wadmission.ValidatingWebhookConfigurationController, secret *corev1.Secret) error {
 	it := &admissionv1.ValidatingWebhookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: fmt.Sprintf("%s.%s", SchemeGroupVersion.Version, SchemeGroupVersion.Group),
+			Name: fmt.Sprintf("%s.%s", SchemeGroupVersionV1alpha1.Version, SchemeGroupVersionV1alpha1.Group),
 		},
 	}
 	err := CreateOrUpdate(it, validatingClient, func(validating *admissionv1.ValidatingWebhookConfiguration) {
@@ -105,6 +125,7 @@ func syncValidatingWebhook(validatingClient wadmission.ValidatingWebhookConfigur
 						Namespace: namespace,
 						Name:      serviceName,
 						Path:      ptr("/validating"),
+						Port:      ptr(int32(defaultHTTPSPort)),
 					},
 					CABundle: secret.Data[corev1.TLSCertKey],
 				},
@@ -112,7 +133,7 @@ func syncValidatingWebhook(validatingClient wadmission.ValidatingWebhookConfigur
 					{
 						Operations: []admissionv1.OperationType{"*"},
 						Rule: admissionv1.Rule{
-							APIGroups:   []string{"tomlebreux.com"},
+							APIGroups:   []string{GroupName},
 							APIVersions: []string{"*"},
 							Resources:   []string{"*"},
 						},